@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
@@ -12,19 +13,28 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/tsironi93/miniHttp/internal/compress"
 	"github.com/tsironi93/miniHttp/internal/headers"
 	"github.com/tsironi93/miniHttp/internal/request"
 	"github.com/tsironi93/miniHttp/internal/response"
+	"github.com/tsironi93/miniHttp/internal/router"
 	"github.com/tsironi93/miniHttp/internal/server"
 )
 
 const (
-	port          = 42069
-	targetHTTPBin = "/httpbin"
-	HTTPBinUrl    = "https://httpbin.org"
+	port            = 42069
+	shutdownTimeout = 10 * time.Second
+	targetHTTPBin   = "/httpbin"
+	HTTPBinUrl      = "https://httpbin.org"
+	styleSheet      = "./internal/htmlTemplates/style.css"
 )
 
+// pageHints are sent as 103 Early Hints ahead of every HTML page, so the
+// browser fetches the stylesheet while the page is being rendered.
+var pageHints = []string{"</style.css>; rel=preload; as=style"}
+
 func loadHtml(path string) string {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -34,41 +44,68 @@ func loadHtml(path string) string {
 	return string(data)
 }
 
-func handleVideo(w *response.Writer) {
+func handleVideo(w *response.Writer, req *request.Request) {
 	videoPath := "./assets/vim.mp4"
 
-	data, err := os.ReadFile(videoPath)
+	f, err := os.Open(videoPath)
 	if err != nil {
 		log.Println(err)
-		response.WriteBadRequestResponse(w.Out)
+		w.SetError(response.StatusBadRequest)
 		return
 	}
+	defer f.Close()
 
-	h :=response.GetDefaultHeaders()
-	h[response.ContType] = "video/mp4"
-	w.WriteHeaders()
+	w.Headers.Set(response.ContType, "video/mp4")
+	if info, err := f.Stat(); err == nil {
+		w.Headers.Set(response.ContLen, strconv.FormatInt(info.Size(), 10))
+	}
 
-	w.Write(data)
-	w.WriteResponse()
+	if _, err := io.Copy(w, f); err != nil {
+		log.Println("Error streaming video:", err)
+	}
 }
 
-func htmlHandler(w *response.Writer, req *request.Request) {
-	switch req.RequestLine.RequestTarget {
-	case "/yourproblem":
-		w.StatusCode = response.StatusBadRequest
-		w.WriteString(loadHtml("./internal/htmlTemplates/400.html"))
-	case "/myproblem":
-		w.StatusCode = response.StatusInternalServerError
-		w.WriteString(loadHtml("./internal/htmlTemplates/500.html"))
-	case "/video":
-		handleVideo(w)
-	default:
-		w.StatusCode = response.StatusOK
-		w.WriteString(loadHtml("./internal/htmlTemplates/200.html"))
-	}
-	w.WriteResponse()
+func htmlPage(code response.StatusCode, path string) server.HandlerFunc {
+	return func(w *response.Writer, req *request.Request) {
+		if err := w.WriteEarlyHints(pageHints...); err != nil {
+			log.Println("Error sending early hints:", err)
+		}
+
+		w.StatusCode = code
+		w.WriteString(loadHtml(path))
+		w.WriteResponse()
+	}
+}
+
+func handleStyle(w *response.Writer, req *request.Request) {
+	w.Headers.Set(response.ContType, "text/css")
+	w.WriteString(loadHtml(styleSheet))
 }
 
+func newHTMLRouter() *router.Router {
+	rt := router.New()
+
+	pages := []struct {
+		pattern string
+		handler server.HandlerFunc
+	}{
+		{"/yourproblem", htmlPage(response.StatusBadRequest, "./internal/htmlTemplates/400.html")},
+		{"/myproblem", htmlPage(response.StatusInternalServerError, "./internal/htmlTemplates/500.html")},
+		{"/video", handleVideo},
+		{"/style.css", handleStyle},
+		{"/{path...}", htmlPage(response.StatusOK, "./internal/htmlTemplates/200.html")},
+	}
+
+	for _, page := range pages {
+		rt.Get(page.pattern, page.handler)
+		rt.Post(page.pattern, page.handler)
+	}
+
+	return rt
+}
+
+var htmlRouter = newHTMLRouter()
+
 func handleHTTPBinProxy(w *response.Writer, req *request.Request) {
 	path := strings.TrimPrefix(req.RequestLine.RequestTarget, targetHTTPBin)
 	if path == "" {
@@ -86,10 +123,10 @@ func handleHTTPBinProxy(w *response.Writer, req *request.Request) {
 	defer resp.Body.Close()
 
 	w.StatusCode = response.StatusCode(resp.StatusCode)
-	delete(w.Headers, response.ContLen)
-	w.Headers[response.TransfEnc] = "chunked"
-	w.Headers["Trailer"] = "X-Content-SHA256, X-Content-Length"
-	w.Headers[response.ContType] = resp.Header.Get(response.ContType)
+	w.Headers.Del(response.ContLen)
+	w.Headers.Set(response.TransfEnc, "chunked")
+	w.Headers.Set("Trailer", "X-Content-SHA256, X-Content-Length")
+	w.Headers.Set(response.ContType, resp.Header.Get(response.ContType))
 
 	if err := w.WriteStatusLine(); err != nil {
 		return
@@ -126,30 +163,52 @@ func handleHTTPBinProxy(w *response.Writer, req *request.Request) {
 	}
 	w.WriteChunkedBodyDone()
 	trailers := headers.NewHeaders()
-	trailers["X-Content-SHA256"] = hex.EncodeToString(hasher.Sum(nil))
-	trailers["X-Content-Length"] = strconv.Itoa(totalLen)
+	trailers.Set("X-Content-SHA256", hex.EncodeToString(hasher.Sum(nil)))
+	trailers.Set("X-Content-Length", strconv.Itoa(totalLen))
 	w.WriteTrailers(trailers)
 }
 
 func mainHandler(w *response.Writer, req *request.Request) {
-	if req.Headers[response.ContType] == "chunked" {
+	if v, _ := req.Headers.Get(response.ContType); v == "chunked" {
 		w.Chunked = true
 		handleHTTPBinProxy(w, req)
 	} else {
-		htmlHandler(w, req)
+		htmlRouter.Serve(w, req)
+	}
+}
+
+func logRequests(next server.HandlerFunc) server.HandlerFunc {
+	return func(w *response.Writer, req *request.Request) {
+		start := time.Now()
+		w.OnWriteHeader(func(w *response.Writer) {
+			log.Printf("%s %s -> %d (%s)", req.RequestLine.Method,
+				req.RequestLine.RequestTarget, w.StatusCode, time.Since(start))
+		})
+		next(w, req)
 	}
 }
 
 func main() {
-	server, err := server.Serve(port, mainHandler)
+	handler := server.Chain(mainHandler,
+		logRequests,
+		compress.Middleware(compress.DefaultConfig()),
+		compress.Decompress(compress.DefaultDecompressConfig()))
+
+	server, err := server.Serve(port, handler)
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
-	defer server.Close()
 	log.Println("Server started on port", port)
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Println("Forced shutdown:", err)
+		return
+	}
 	log.Println("Server gracefully stopped")
 }
@@ -13,7 +13,7 @@ func printRequest(r *request.Request) {
 	fmt.Println("- Target:", r.RequestLine.RequestTarget)
 	fmt.Println("- Version:", r.RequestLine.HttpVersion)
 	fmt.Println("Headers:")
-	for k, v := range r.Headers {
+	for k, v := range r.Headers.All() {
 		fmt.Println("-", k+":", v)
 	}
 	fmt.Println("Body:")
@@ -37,6 +37,8 @@ func main() {
 		req, err := request.RequestFromReader(fd)
 		if err != nil {
 			fmt.Println("parse error:", err)
+		} else if _, err := req.ReadBody(); err != nil {
+			fmt.Println("body error:", err)
 		}
 
 		printRequest(req)
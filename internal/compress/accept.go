@@ -0,0 +1,63 @@
+package compress
+
+import (
+	"strconv"
+	"strings"
+)
+
+// negotiate picks the coding from offered that the Accept-Encoding values
+// rate highest, preferring earlier offers on a tie. It returns "" when the
+// client sent no Accept-Encoding or accepts none of the offers, in which case
+// the body is sent as is.
+func negotiate(accept []string, offered []string) string {
+	if len(accept) == 0 {
+		return ""
+	}
+
+	weights := make(map[string]float64)
+	for _, v := range accept {
+		for _, elem := range strings.Split(v, ",") {
+			name, q, ok := parseCoding(elem)
+			if ok {
+				weights[name] = q
+			}
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range offered {
+		q, ok := weights[coding]
+		if !ok {
+			q = weights["*"]
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// parseCoding parses one Accept-Encoding element such as "gzip;q=0.8". A
+// missing weight means 1; a malformed one makes the element invalid.
+func parseCoding(elem string) (string, float64, bool) {
+	name, params, _ := strings.Cut(elem, ";")
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return "", 0, false
+	}
+
+	q := 1.0
+	for _, p := range strings.Split(params, ";") {
+		key, val, ok := strings.Cut(strings.TrimSpace(p), "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(key), "q") {
+			continue
+		}
+
+		f, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		if err != nil || f < 0 || f > 1 {
+			return "", 0, false
+		}
+		q = f
+	}
+	return name, q, true
+}
@@ -0,0 +1,164 @@
+// Package compress provides middleware that compresses response bodies
+// according to the client's Accept-Encoding, and middleware that decodes
+// request bodies sent with a Content-Encoding.
+package compress
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strings"
+
+	"github.com/tsironi93/miniHttp/internal/request"
+	"github.com/tsironi93/miniHttp/internal/response"
+	"github.com/tsironi93/miniHttp/internal/server"
+)
+
+const (
+	acceptEncoding  = "Accept-Encoding"
+	contentEncoding = "Content-Encoding"
+	vary            = "Vary"
+)
+
+// Encoder wraps dst so that everything written to the result is compressed
+// at the given level; Close writes out the rest of the stream.
+type Encoder func(dst io.Writer, level int) io.WriteCloser
+
+// Gzip and Deflate are the built-in encoders. An invalid level falls back to
+// the default one.
+func Gzip(dst io.Writer, level int) io.WriteCloser {
+	zw, err := gzip.NewWriterLevel(dst, level)
+	if err != nil {
+		zw = gzip.NewWriter(dst)
+	}
+	return zw
+}
+
+// Deflate produces the "deflate" content coding, which HTTP defines as a
+// zlib stream rather than raw DEFLATE data.
+func Deflate(dst io.Writer, level int) io.WriteCloser {
+	zw, err := zlib.NewWriterLevel(dst, level)
+	if err != nil {
+		zw = zlib.NewWriter(dst)
+	}
+	return zw
+}
+
+// Config controls which responses are compressed and how. Start from
+// DefaultConfig.
+type Config struct {
+	// Level is passed to the encoder; for gzip and deflate it ranges from
+	// 1 (fastest) to 9 (smallest), with -1 for the default.
+	Level int
+	// MinSize is the smallest body worth compressing. Bodies whose size is
+	// not known up front because they are streamed are always compressed.
+	MinSize int64
+	// ContentTypes lists the media types to compress. An entry such as
+	// "text/*" matches a whole type.
+	ContentTypes []string
+	// Encodings lists the content codings to offer, most preferred first.
+	// Each needs an entry in Encoders.
+	Encodings []string
+	// Encoders maps content-coding names to encoders. Register a brotli
+	// encoder under "br" and add "br" to Encodings to enable it.
+	Encoders map[string]Encoder
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Level:   gzip.DefaultCompression,
+		MinSize: 1 << 10,
+		ContentTypes: []string{
+			"text/*",
+			"application/json",
+			"application/javascript",
+			"application/xml",
+			"image/svg+xml",
+		},
+		Encodings: []string{"gzip", "deflate"},
+		Encoders: map[string]Encoder{
+			"gzip":    Gzip,
+			"deflate": Deflate,
+		},
+	}
+}
+
+// Middleware compresses response bodies with the best coding both sides
+// support. The decision is made right before the status line goes out, so it
+// covers buffered responses, streamed ones and bodies written with
+// WriteChunkedBody alike. Responses that already have a Content-Encoding are
+// left alone.
+func Middleware(cfg Config) server.Middleware {
+	return func(next server.HandlerFunc) server.HandlerFunc {
+		return func(w *response.Writer, req *request.Request) {
+			w.OnWriteHeader(func(w *response.Writer) {
+				cfg.apply(w, req)
+			})
+			next(w, req)
+		}
+	}
+}
+
+func (cfg *Config) apply(w *response.Writer, req *request.Request) {
+	if w.StatusCode.IsInformational() ||
+		w.StatusCode == response.StatusNoContent ||
+		w.StatusCode == response.StatusNotModified ||
+		w.Headers.Has(contentEncoding) {
+		return
+	}
+
+	ct, _ := w.Headers.Get(response.ContType)
+	if !cfg.compressible(ct) {
+		return
+	}
+
+	// Whether or not this response ends up compressed, caches must know
+	// that it depends on Accept-Encoding.
+	addVary(w, acceptEncoding)
+
+	if n, ok := w.ContentLength(); ok && n < cfg.MinSize {
+		return
+	}
+
+	coding := negotiate(req.Headers.Values(acceptEncoding), cfg.Encodings)
+	enc := cfg.Encoders[coding]
+	if enc == nil {
+		return
+	}
+
+	w.Headers.Set(contentEncoding, coding)
+	w.SetEncoder(func(dst io.Writer) io.WriteCloser {
+		return enc(dst, cfg.Level)
+	})
+}
+
+func (cfg *Config) compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == "" {
+		return false
+	}
+
+	for _, t := range cfg.ContentTypes {
+		if prefix, ok := strings.CutSuffix(t, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+func addVary(w *response.Writer, field string) {
+	for _, v := range w.Headers.Values(vary) {
+		for _, f := range strings.Split(v, ",") {
+			f = strings.TrimSpace(f)
+			if f == "*" || strings.EqualFold(f, field) {
+				return
+			}
+		}
+	}
+	w.Headers.Add(vary, field)
+}
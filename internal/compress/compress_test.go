@@ -0,0 +1,357 @@
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tsironi93/miniHttp/internal/request"
+	"github.com/tsironi93/miniHttp/internal/response"
+	"github.com/tsironi93/miniHttp/internal/server"
+)
+
+// bufConn records everything written to it.
+type bufConn struct {
+	net.Conn
+	out bytes.Buffer
+}
+
+func (c *bufConn) Write(p []byte) (int, error) {
+	return c.out.Write(p)
+}
+
+// serve runs handler behind the middleware and parses what it sent.
+func serve(t *testing.T, cfg Config, method, acceptEnc string, handler server.HandlerFunc) (*http.Response, []byte) {
+	t.Helper()
+
+	raw := method + " / HTTP/1.1\r\nHost: localhost\r\n"
+	if acceptEnc != "" {
+		raw += "Accept-Encoding: " + acceptEnc + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+
+	conn := &bufConn{}
+	w := response.NewWriter(conn)
+	w.SetRequestMethod(method)
+	Middleware(cfg)(handler)(w, req)
+	w.Finish()
+
+	resp, err := http.ReadResponse(bufio.NewReader(&conn.out), &http.Request{Method: method})
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, body
+}
+
+func gunzip(t *testing.T, data []byte) string {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	out, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	return string(out)
+}
+
+func TestNegotiate(t *testing.T) {
+	offered := []string{"br", "gzip", "deflate"}
+
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"gzip", "gzip"},
+		{"deflate, gzip", "gzip"},
+		{"GZIP;q=0.5, deflate", "deflate"},
+		{"gzip;q=0, deflate;q=0.1", "deflate"},
+		{"*", "br"},
+		{"*;q=0.5, gzip;q=0.8", "gzip"},
+		{"*, br;q=0", "gzip"},
+		{"identity", ""},
+		{"gzip;q=0", ""},
+		{"gzip;q=abc, deflate", "deflate"},
+		{"gzip;q=2", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		var accept []string
+		if tt.accept != "" {
+			accept = []string{tt.accept}
+		}
+		assert.Equal(t, tt.want, negotiate(accept, offered), tt.accept)
+	}
+
+	// Test: Repeated Accept-Encoding fields are combined
+	assert.Equal(t, "deflate", negotiate([]string{"gzip;q=0.1", "deflate"}, offered))
+}
+
+func TestMiddleware(t *testing.T) {
+	page := strings.Repeat("<p>hello, compressed world</p>\n", 100)
+	htmlPage := func(w *response.Writer, req *request.Request) {
+		w.WriteString(page)
+	}
+
+	// Test: Buffered body is compressed and keeps a Content-Length
+	resp, body := serve(t, DefaultConfig(), "GET", "gzip, deflate", htmlPage)
+	assert.Equal(t, "gzip", resp.Header.Get(contentEncoding))
+	assert.Equal(t, acceptEncoding, resp.Header.Get(vary))
+	assert.Equal(t, int64(len(body)), resp.ContentLength)
+	assert.Less(t, len(body), len(page))
+	assert.Equal(t, page, gunzip(t, body))
+
+	// Test: Deflate when preferred by q-value
+	resp, body = serve(t, DefaultConfig(), "GET", "gzip;q=0.5, deflate", htmlPage)
+	assert.Equal(t, "deflate", resp.Header.Get(contentEncoding))
+	zr, err := zlib.NewReader(bytes.NewReader(body))
+	require.NoError(t, err)
+	out, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, page, string(out))
+
+	// Test: Streamed body is compressed chunked
+	big := strings.Repeat("x", 20000)
+	resp, body = serve(t, DefaultConfig(), "GET", "gzip", func(w *response.Writer, req *request.Request) {
+		w.WriteString(big)
+		w.WriteString("tail")
+	})
+	assert.Equal(t, "gzip", resp.Header.Get(contentEncoding))
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.Equal(t, big+"tail", gunzip(t, body))
+
+	// Test: WriteChunkedBody path is compressed too
+	resp, body = serve(t, DefaultConfig(), "GET", "gzip", func(w *response.Writer, req *request.Request) {
+		w.Headers.Set(response.TransfEnc, "chunked")
+		w.Headers.Set(response.ContType, "application/json")
+		require.NoError(t, w.WriteStatusLine())
+		require.NoError(t, w.WriteHeaders())
+		for i := 0; i < 50; i++ {
+			w.WriteChunkedBody([]byte(`{"n": 1}`))
+		}
+		w.WriteChunkedBodyDone()
+	})
+	assert.Equal(t, "gzip", resp.Header.Get(contentEncoding))
+	assert.Equal(t, strings.Repeat(`{"n": 1}`, 50), gunzip(t, body))
+
+	// Test: Small body is sent as is, but still varies
+	resp, body = serve(t, DefaultConfig(), "GET", "gzip", func(w *response.Writer, req *request.Request) {
+		w.WriteString("tiny")
+	})
+	assert.Equal(t, "", resp.Header.Get(contentEncoding))
+	assert.Equal(t, acceptEncoding, resp.Header.Get(vary))
+	assert.Equal(t, "tiny", string(body))
+
+	// Test: Content type outside the allow-list
+	resp, body = serve(t, DefaultConfig(), "GET", "gzip", func(w *response.Writer, req *request.Request) {
+		w.Headers.Set(response.ContType, "video/mp4")
+		w.WriteString(page)
+	})
+	assert.Equal(t, "", resp.Header.Get(contentEncoding))
+	assert.Equal(t, "", resp.Header.Get(vary))
+	assert.Equal(t, page, string(body))
+
+	// Test: Client without Accept-Encoding
+	resp, body = serve(t, DefaultConfig(), "GET", "", htmlPage)
+	assert.Equal(t, "", resp.Header.Get(contentEncoding))
+	assert.Equal(t, page, string(body))
+
+	// Test: Existing Content-Encoding is left alone
+	resp, _ = serve(t, DefaultConfig(), "GET", "gzip", func(w *response.Writer, req *request.Request) {
+		w.Headers.Set(contentEncoding, "br")
+		w.WriteString(page)
+	})
+	assert.Equal(t, "br", resp.Header.Get(contentEncoding))
+
+	// Test: HEAD gets the same Content-Length as GET
+	get, _ := serve(t, DefaultConfig(), "GET", "gzip", htmlPage)
+	head, body := serve(t, DefaultConfig(), "HEAD", "gzip", htmlPage)
+	assert.Equal(t, "gzip", head.Header.Get(contentEncoding))
+	assert.Equal(t, get.Header.Get(response.ContLen), head.Header.Get(response.ContLen))
+	assert.Empty(t, body)
+}
+
+func TestCustomEncoder(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Encoders["br"] = func(dst io.Writer, level int) io.WriteCloser {
+		return nopEncoder{dst}
+	}
+	cfg.Encodings = append([]string{"br"}, cfg.Encodings...)
+
+	page := strings.Repeat("a", 2000)
+	resp, body := serve(t, cfg, "GET", "gzip, br", func(w *response.Writer, req *request.Request) {
+		w.WriteString(page)
+	})
+	assert.Equal(t, "br", resp.Header.Get(contentEncoding))
+	assert.Equal(t, page, string(body))
+}
+
+type nopEncoder struct {
+	io.Writer
+}
+
+func (nopEncoder) Close() error { return nil }
+
+// upload sends body with the given Content-Encoding through the Decompress
+// middleware and returns what the handler read and the response.
+func upload(t *testing.T, cfg DecompressConfig, coding string, body []byte) (string, *response.Writer, *request.Request, error) {
+	t.Helper()
+
+	raw := "POST / HTTP/1.1\r\nHost: localhost\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n"
+	if coding != "" {
+		raw += "Content-Encoding: " + coding + "\r\n"
+	}
+	req, err := request.RequestFromReader(io.MultiReader(
+		strings.NewReader(raw+"\r\n"), bytes.NewReader(body)))
+	require.NoError(t, err)
+
+	var got []byte
+	var readErr error
+	w := response.NewWriter(nil)
+	Decompress(cfg)(func(w *response.Writer, req *request.Request) {
+		got, readErr = req.ReadBody()
+	})(w, req)
+	return string(got), w, req, readErr
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func zlibbed(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	_, err := zw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestDecompress(t *testing.T) {
+	payload := []byte(`{"temperature": 21.5, "humidity": 40}`)
+
+	// Test: Gzip body is decoded and the headers no longer claim it
+	got, _, req, err := upload(t, DefaultDecompressConfig(), "gzip", gzipped(t, payload))
+	require.NoError(t, err)
+	assert.Equal(t, string(payload), got)
+	assert.False(t, req.Headers.Has(contentEncoding))
+	assert.False(t, req.Headers.Has(response.ContLen))
+
+	// Test: Deflate body
+	got, _, _, err = upload(t, DefaultDecompressConfig(), "deflate", zlibbed(t, payload))
+	require.NoError(t, err)
+	assert.Equal(t, string(payload), got)
+
+	// Test: Several codings are undone in reverse order
+	got, _, _, err = upload(t, DefaultDecompressConfig(), "deflate, GZIP",
+		gzipped(t, zlibbed(t, payload)))
+	require.NoError(t, err)
+	assert.Equal(t, string(payload), got)
+
+	// Test: Uncoded and identity bodies pass through untouched
+	got, _, req, err = upload(t, DefaultDecompressConfig(), "identity", payload)
+	require.NoError(t, err)
+	assert.Equal(t, string(payload), got)
+	assert.True(t, req.Headers.Has(response.ContLen))
+
+	// Test: Unsupported coding
+	got, w, _, _ := upload(t, DefaultDecompressConfig(), "br", payload)
+	assert.Equal(t, "", got)
+	assert.Equal(t, response.StatusUnsupportedMediaType, w.StatusCode)
+	v, _ := w.Headers.Get(acceptEncoding)
+	assert.Equal(t, "deflate, gzip, x-gzip", v)
+
+	// Test: Corrupt data surfaces as a read error
+	_, _, _, err = upload(t, DefaultDecompressConfig(), "gzip", payload)
+	require.Error(t, err)
+
+	// Test: Compression bomb is cut off at MaxSize
+	cfg := DefaultDecompressConfig()
+	cfg.MaxSize = 1 << 10
+	bomb := gzipped(t, make([]byte, 1<<20))
+	assert.Less(t, len(bomb), 4<<10)
+	_, w, _, err = upload(t, cfg, "gzip", bomb)
+	require.ErrorIs(t, err, ErrDecodedBodyTooLarge)
+	assert.Equal(t, response.StatusContentTooLarge, w.StatusCode)
+}
+
+func TestDecompressKeepAlive(t *testing.T) {
+	cfg := DefaultDecompressConfig()
+	cfg.MaxSize = 64 << 10
+	s, err := server.ServeConfig(server.Config{
+		Addr: "127.0.0.1:0",
+		Handler: Decompress(cfg)(func(w *response.Writer, req *request.Request) {
+			if req.RequestLine.Path == "/partial" {
+				buf := make([]byte, 4)
+				io.ReadFull(req.BodyReader, buf)
+				w.Write(buf)
+				return
+			}
+			req.ReadBody()
+			w.WriteString(req.RequestLine.Path)
+		}),
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	br := bufio.NewReader(conn)
+
+	send := func(path string, body []byte) *http.Response {
+		t.Helper()
+		_, err := io.WriteString(conn, "POST "+path+" HTTP/1.1\r\nHost: localhost\r\n"+
+			"Content-Encoding: gzip\r\nContent-Length: "+strconv.Itoa(len(body))+"\r\n\r\n")
+		require.NoError(t, err)
+		_, err = conn.Write(body)
+		require.NoError(t, err)
+		resp, err := http.ReadResponse(br, nil)
+		require.NoError(t, err)
+		return resp
+	}
+
+	// Random data doesn't compress, so the handler stops reading well
+	// before the end of what was sent.
+	payload := make([]byte, 128<<10)
+	rand.New(rand.NewSource(1)).Read(payload)
+
+	// Test: Compressed body that is only partly read
+	resp := send("/partial", gzipped(t, payload))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, payload[:4], body)
+	assert.False(t, resp.Close)
+
+	// Test: Next request on the same connection
+	resp = send("/next", gzipped(t, []byte("hello")))
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "/next", string(body))
+
+	// Test: Compression bomb closes the connection
+	resp = send("/bomb", gzipped(t, make([]byte, 1<<20)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	assert.True(t, resp.Close)
+}
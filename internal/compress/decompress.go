@@ -0,0 +1,187 @@
+package compress
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/tsironi93/miniHttp/internal/request"
+	"github.com/tsironi93/miniHttp/internal/response"
+	"github.com/tsironi93/miniHttp/internal/server"
+)
+
+// ErrDecodedBodyTooLarge is returned from the request body once it decodes
+// to more than DecompressConfig.MaxSize bytes.
+var ErrDecodedBodyTooLarge = errors.New("decoded request body too large")
+
+// Decoder wraps src so that reading from the result yields the decoded data.
+type Decoder func(src io.Reader) (io.ReadCloser, error)
+
+func GunzipBody(src io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(src)
+}
+
+func InflateBody(src io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(src)
+}
+
+// DecompressConfig controls how compressed request bodies are decoded. Start
+// from DefaultDecompressConfig.
+type DecompressConfig struct {
+	// MaxSize caps the decoded body, separately from the request limits
+	// that apply to the bytes on the wire, so that a small compressed
+	// body can't expand without bound. Zero means no limit.
+	MaxSize int64
+	// Decoders maps content-coding names to decoders. A request using any
+	// other coding is answered with 415 Unsupported Media Type.
+	Decoders map[string]Decoder
+}
+
+func DefaultDecompressConfig() DecompressConfig {
+	return DecompressConfig{
+		MaxSize: 32 << 20,
+		Decoders: map[string]Decoder{
+			"gzip":    GunzipBody,
+			"x-gzip":  GunzipBody,
+			"deflate": InflateBody,
+		},
+	}
+}
+
+// Decompress decodes request bodies sent with a Content-Encoding, so handlers
+// read plain data from BodyReader. Content-Encoding and Content-Length are
+// removed from the request headers since they no longer describe the body.
+// If the decoded body outgrows MaxSize, reads fail with
+// ErrDecodedBodyTooLarge and, unless the handler already started its
+// response, the client gets 413 Content Too Large and the connection is
+// closed.
+func Decompress(cfg DecompressConfig) server.Middleware {
+	return func(next server.HandlerFunc) server.HandlerFunc {
+		return func(w *response.Writer, req *request.Request) {
+			codings, err := cfg.codings(req.Headers.Values(contentEncoding))
+			if err != nil {
+				w.SetError(response.StatusUnsupportedMediaType)
+				w.Headers.Set(acceptEncoding, strings.Join(cfg.names(), ", "))
+				return
+			}
+
+			if len(codings) == 0 {
+				next(w, req)
+				return
+			}
+
+			body := &decodedBody{
+				src:      req.BodyReader,
+				codings:  codings,
+				decoders: cfg.Decoders,
+				max:      cfg.MaxSize,
+			}
+			req.BodyReader = body
+			req.Headers.Del(contentEncoding)
+			req.Headers.Del(response.ContLen)
+
+			next(w, req)
+
+			if body.tooLarge && !w.Written() {
+				w.SetError(response.StatusContentTooLarge)
+				w.SetKeepAlive(false)
+			}
+		}
+	}
+}
+
+// codings lists the content codings applied to the body, in the order they
+// were applied, leaving out identity. It fails on a coding with no decoder.
+func (cfg *DecompressConfig) codings(values []string) ([]string, error) {
+	var codings []string
+	for _, v := range values {
+		for _, c := range strings.Split(v, ",") {
+			c = strings.ToLower(strings.TrimSpace(c))
+			switch {
+			case c == "" || c == "identity":
+			case cfg.Decoders[c] != nil:
+				codings = append(codings, c)
+			default:
+				return nil, fmt.Errorf("unsupported content coding %q", c)
+			}
+		}
+	}
+	return codings, nil
+}
+
+func (cfg *DecompressConfig) names() []string {
+	names := make([]string, 0, len(cfg.Decoders))
+	for name := range cfg.Decoders {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// decodedBody undoes the content codings of a request body. The decoders
+// are only set up on the first Read, since a gzip reader consumes its header
+// straight away and the handler may never want the body.
+type decodedBody struct {
+	src      io.ReadCloser
+	codings  []string
+	decoders map[string]Decoder
+	max      int64
+
+	rd       io.Reader
+	closers  []io.Closer
+	read     int64
+	err      error
+	tooLarge bool
+}
+
+func (b *decodedBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	if b.rd == nil {
+		if err := b.open(); err != nil {
+			b.err = err
+			return 0, err
+		}
+	}
+
+	n, err := b.rd.Read(p)
+	b.read += int64(n)
+	if b.max > 0 && b.read > b.max {
+		b.tooLarge = true
+		b.err = ErrDecodedBodyTooLarge
+		return 0, b.err
+	}
+	return n, err
+}
+
+// open stacks the decoders, the last applied coding being undone first.
+func (b *decodedBody) open() error {
+	var rd io.Reader = b.src
+	for _, c := range slices.Backward(b.codings) {
+		dec, err := b.decoders[c](rd)
+		if err != nil {
+			return err
+		}
+		b.closers = append(b.closers, dec)
+		rd = dec
+	}
+	b.rd = rd
+	return nil
+}
+
+// Close releases the decoders and skips the rest of the encoded body. Later
+// reads return io.EOF.
+func (b *decodedBody) Close() error {
+	for _, c := range b.closers {
+		c.Close()
+	}
+	b.closers = nil
+	b.err = io.EOF
+	return b.src.Close()
+}
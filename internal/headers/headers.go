@@ -3,15 +3,49 @@ package headers
 import (
 	"bytes"
 	"errors"
+	"iter"
 	"strings"
 )
 
 const crlf = "\r\n"
 
-type Headers map[string]string
+type field struct {
+	name  string
+	value string
+}
+
+// Headers is an ordered list of header fields. Repeated names keep every
+// value, so fields that cannot be combined (like Set-Cookie) survive, and
+// fields are written back in the order they were added.
+type Headers struct {
+	fields []field
+}
 
-func NewHeaders() Headers {
-	return make(Headers)
+func NewHeaders() *Headers {
+	return &Headers{}
+}
+
+// CanonicalKey returns key in canonical case: the first letter and every
+// letter following a hyphen are upper case, the rest lower case, so
+// "content-LENGTH" becomes "Content-Length". Keys that are not valid field
+// names are returned unchanged.
+func CanonicalKey(key string) string {
+	if !IsToken(key) {
+		return key
+	}
+
+	b := []byte(key)
+	upper := true
+	for i, c := range b {
+		switch {
+		case upper && c >= 'a' && c <= 'z':
+			b[i] = c - ('a' - 'A')
+		case !upper && c >= 'A' && c <= 'Z':
+			b[i] = c + ('a' - 'A')
+		}
+		upper = c == '-'
+	}
+	return string(b)
 }
 
 func isValidValue(s string) bool {
@@ -23,9 +57,95 @@ func isValidValue(s string) bool {
 	return true
 }
 
-func (h Headers) Get(key string) (string, bool) {
-	v, ok := h[key]
-	return v, ok
+// Get returns the first value stored under key. Like every lookup on
+// Headers, it ignores the case of key.
+func (h *Headers) Get(key string) (string, bool) {
+	key = CanonicalKey(key)
+	for _, f := range h.fields {
+		if f.name == key {
+			return f.value, true
+		}
+	}
+	return "", false
+}
+
+// Values returns every value stored under key, in order.
+func (h *Headers) Values(key string) []string {
+	key = CanonicalKey(key)
+	var values []string
+	for _, f := range h.fields {
+		if f.name == key {
+			values = append(values, f.value)
+		}
+	}
+	return values
+}
+
+// Add appends a field, keeping any existing values for key. The key is
+// stored in canonical case.
+func (h *Headers) Add(key, value string) {
+	h.fields = append(h.fields, field{name: CanonicalKey(key), value: value})
+}
+
+// Set replaces all values for key with value. The field keeps the position
+// of the first existing one, or goes last if key is new.
+func (h *Headers) Set(key, value string) {
+	key = CanonicalKey(key)
+	for i, f := range h.fields {
+		if f.name == key {
+			h.fields[i].value = value
+			h.delFrom(key, i+1)
+			return
+		}
+	}
+	h.Add(key, value)
+}
+
+// Del removes every value for key.
+func (h *Headers) Del(key string) {
+	h.delFrom(CanonicalKey(key), 0)
+}
+
+func (h *Headers) delFrom(key string, start int) {
+	kept := h.fields[:start]
+	for _, f := range h.fields[start:] {
+		if f.name != key {
+			kept = append(kept, f)
+		}
+	}
+	h.fields = kept
+}
+
+// Has reports whether key has at least one value.
+func (h *Headers) Has(key string) bool {
+	_, ok := h.Get(key)
+	return ok
+}
+
+// Len returns the number of fields, counting repeated names separately.
+func (h *Headers) Len() int {
+	return len(h.fields)
+}
+
+func (h *Headers) Clone() *Headers {
+	return &Headers{fields: append([]field(nil), h.fields...)}
+}
+
+// All yields every field in order.
+func (h *Headers) All() iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		for _, f := range h.fields {
+			if !yield(f.name, f.value) {
+				return
+			}
+		}
+	}
+}
+
+// IsToken reports whether s is a non-empty token as defined by RFC 9110,
+// the syntax shared by field names and request methods.
+func IsToken(s string) bool {
+	return s != "" && isValidKey(s)
 }
 
 func isValidKey(s string) bool {
@@ -42,7 +162,7 @@ func isValidKey(s string) bool {
 	return true
 }
 
-func (h Headers) Parse(data []byte) (n int, done bool, err error) {
+func (h *Headers) Parse(data []byte) (n int, done bool, err error) {
 
 	for len(data) > 0 {
 		idx := bytes.Index(data, []byte(crlf))
@@ -64,7 +184,7 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 			return n, false, errors.New("invalid characters found")
 		}
 
-		key := strings.TrimSpace(strings.ToLower(parts[0]))
+		key := strings.TrimSpace(parts[0])
 		value := strings.TrimSpace(parts[1])
 
 		colonIdx := strings.Index(line, ":")
@@ -76,12 +196,7 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 			return n, false, errors.New("empty header key")
 		}
 
-		v, ok := h[key]
-		if ok {
-			h[key] = v + ", " + value
-		} else {
-			h[key] = value
-		}
+		h.Add(key, value)
 
 		n += idx + 2
 		data = data[idx+2:]
@@ -7,6 +7,12 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+// value returns the first value of key, or "" if it is missing.
+func value(h *Headers, key string) string {
+	v, _ := h.Get(key)
+	return v
+}
+
 func TestParseHeaders(t *testing.T) {
 
 	t.Run("Valid single header", func(t *testing.T) {
@@ -15,7 +21,7 @@ func TestParseHeaders(t *testing.T) {
 
 		n, done, err := h.Parse(data)
 		require.NoError(t, err)
-		assert.Equal(t, "localhost:42069", h["host"])
+		assert.Equal(t, "localhost:42069", value(h, "host"))
 		assert.Equal(t, 25, n)
 		assert.True(t, done)
 	})
@@ -24,15 +30,15 @@ func TestParseHeaders(t *testing.T) {
 		h := NewHeaders()
 
 		// Pretend parser already has one header
-		h["User-Agent"] = "curl/8.0"
+		h.Add("User-Agent", "curl/8.0")
 
 		data := []byte("Host: example.com\r\nAccept: */*\r\n\r\n")
 
 		n, done, err := h.Parse(data)
 		require.NoError(t, err)
-		assert.Equal(t, "example.com", h["host"])
-		assert.Equal(t, "*/*", h["accept"])
-		assert.Equal(t, "curl/8.0", h["User-Agent"])
+		assert.Equal(t, "example.com", value(h, "host"))
+		assert.Equal(t, "*/*", value(h, "accept"))
+		assert.Equal(t, "curl/8.0", value(h, "User-Agent"))
 		assert.Equal(t, len(data), n)
 		assert.True(t, done)
 	})
@@ -61,7 +67,7 @@ func TestParseHeaders(t *testing.T) {
 	data := []byte("Host: example.com\r\n\r\n")
 	n, done, err := h.Parse(data)
 	require.NoError(t, err)
-	assert.Equal(t, "example.com", h["host"]) // key is lowercase
+	assert.Equal(t, "example.com", value(h, "host")) // lookups are case-insensitive
 	assert.Equal(t, 21, n)
 	assert.True(t, done)
 
@@ -86,7 +92,7 @@ func TestParseHeaders(t *testing.T) {
 	data = []byte("Conten^t-Type: text/html\r\n\r\n")
 	n, done, err = h.Parse(data)
 	require.NoError(t, err)
-	assert.Equal(t, "text/html", h["conten^t-type"])
+	assert.Equal(t, "text/html", value(h, "conten^t-type"))
 	assert.True(t, done)
 
 	// Test: Empty key
@@ -102,7 +108,95 @@ func TestParseHeaders(t *testing.T) {
 	data = []byte("Host: localhost69420\r\nSet-Person: lane-loves-go\r\nSet-Person: prime-loves-zig\r\nSet-Person: tj-loves-ocaml\r\n\r\n")
 	n, done, err = h.Parse(data)
 	require.NoError(t, err)
-	assert.Equal(t, "lane-loves-go, prime-loves-zig, tj-loves-ocaml", h["set-person"])
+	assert.Equal(t, []string{"lane-loves-go", "prime-loves-zig", "tj-loves-ocaml"}, h.Values("set-person"))
 	require.Equal(t, 108, n)
 	assert.True(t, done)
 }
+
+func TestHeadersOrderAndValues(t *testing.T) {
+	h := NewHeaders()
+	h.Add("Content-Type", "text/html")
+	h.Add("Set-Cookie", "a=1; Path=/")
+	h.Add("Set-Cookie", "b=2; Expires=Wed, 21 Oct 2026 07:28:00 GMT")
+	h.Add("X-Trace", "abc")
+
+	// Test: Every value is kept, in order
+	assert.Equal(t, []string{"a=1; Path=/", "b=2; Expires=Wed, 21 Oct 2026 07:28:00 GMT"}, h.Values("Set-Cookie"))
+	assert.Equal(t, "a=1; Path=/", value(h, "Set-Cookie"))
+	assert.Equal(t, 4, h.Len())
+
+	var names []string
+	for k := range h.All() {
+		names = append(names, k)
+	}
+	assert.Equal(t, []string{"Content-Type", "Set-Cookie", "Set-Cookie", "X-Trace"}, names)
+
+	// Test: Clone is independent
+	c := h.Clone()
+	c.Add("X-Extra", "1")
+	c.Set("Content-Type", "text/plain")
+	assert.False(t, h.Has("X-Extra"))
+	assert.Equal(t, "text/html", value(h, "Content-Type"))
+
+	// Test: Set replaces every value in place of the first one
+	h.Set("Set-Cookie", "c=3")
+	assert.Equal(t, []string{"c=3"}, h.Values("Set-Cookie"))
+	names = names[:0]
+	for k := range h.All() {
+		names = append(names, k)
+	}
+	assert.Equal(t, []string{"Content-Type", "Set-Cookie", "X-Trace"}, names)
+
+	// Test: Set on a new key appends it
+	h.Set("X-New", "yes")
+	assert.Equal(t, "yes", value(h, "X-New"))
+
+	// Test: Del removes every value
+	h.Add("X-Trace", "def")
+	h.Del("X-Trace")
+	assert.False(t, h.Has("X-Trace"))
+	assert.Nil(t, h.Values("X-Trace"))
+	assert.Equal(t, 3, h.Len())
+}
+
+func TestParseKeepsRepeatedFields(t *testing.T) {
+	h := NewHeaders()
+	data := []byte("Set-Cookie: a=1\r\nHost: example.com\r\nSet-Cookie: b=2, c=3\r\n\r\n")
+	_, done, err := h.Parse(data)
+	require.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, []string{"a=1", "b=2, c=3"}, h.Values("set-cookie"))
+
+	var names []string
+	for k := range h.All() {
+		names = append(names, k)
+	}
+	assert.Equal(t, []string{"Set-Cookie", "Host", "Set-Cookie"}, names)
+}
+
+func TestCaseInsensitiveAccess(t *testing.T) {
+	assert.Equal(t, "Content-Length", CanonicalKey("content-length"))
+	assert.Equal(t, "Content-Length", CanonicalKey("CONTENT-LENGTH"))
+	assert.Equal(t, "X-Content-Sha256", CanonicalKey("x-content-SHA256"))
+	assert.Equal(t, "Www-Authenticate", CanonicalKey("WWW-Authenticate"))
+	assert.Equal(t, "bad key", CanonicalKey("bad key"))
+
+	h := NewHeaders()
+	h.Set("content-length", "10")
+	h.Set("Content-Length", "20")
+	assert.Equal(t, 1, h.Len())
+	assert.Equal(t, "20", value(h, "CONTENT-LENGTH"))
+
+	h.Add("set-cookie", "a=1")
+	h.Add("SET-COOKIE", "b=2")
+	assert.Equal(t, []string{"a=1", "b=2"}, h.Values("Set-Cookie"))
+
+	h.Del("set-COOKIE")
+	assert.False(t, h.Has("Set-Cookie"))
+
+	var names []string
+	for k := range h.All() {
+		names = append(names, k)
+	}
+	assert.Equal(t, []string{"Content-Length"}, names)
+}
@@ -0,0 +1,136 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxDiscard is how much unread body Close is willing to skip so the
+// connection can be reused. Anything larger is cheaper to drop with the
+// connection.
+const maxDiscard = 256 << 10
+
+var ErrBodyNotConsumed = errors.New("request body was not fully read")
+
+// ErrExpectationFailed is returned for an Expect field other than
+// 100-continue, the only expectation HTTP defines.
+var ErrExpectationFailed = errors.New("expectation failed")
+
+// bodyReader pulls the body from the connection on demand, decoding it
+// according to the framing chosen when the headers were parsed.
+type bodyReader struct {
+	rd     *Reader
+	req    *Request
+	err    error
+	closed bool
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	for len(b.req.bodyBuf) == 0 {
+		if b.req.State.parseState == DONE {
+			return 0, io.EOF
+		}
+
+		if b.closed {
+			return 0, errors.New("read on closed request body")
+		}
+
+		if b.err != nil {
+			return 0, b.err
+		}
+
+		if fn := b.req.onBodyRead; fn != nil {
+			b.req.onBodyRead = nil
+			if err := fn(); err != nil {
+				b.err = err
+				return 0, err
+			}
+		}
+
+		if err := b.rd.advance(b.req); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			b.err = err
+			return 0, err
+		}
+	}
+
+	n := copy(p, b.req.bodyBuf)
+	b.req.bodyBuf = b.req.bodyBuf[n:]
+	return n, nil
+}
+
+// Close discards a small unread remainder of the body so the next request on
+// the connection can be parsed. It returns ErrBodyNotConsumed when too much
+// is left, in which case the connection should not be reused.
+func (b *bodyReader) Close() error {
+	if b.closed {
+		return nil
+	}
+
+	// A client still waiting for 100 Continue won't send the body, so
+	// there is nothing to skip and the connection can't be reused.
+	if b.req.expectContinue && b.req.onBodyRead != nil {
+		b.closed = true
+		return ErrBodyNotConsumed
+	}
+
+	n, err := io.CopyN(io.Discard, b, maxDiscard+1)
+	b.closed = true
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if n > maxDiscard {
+		return ErrBodyNotConsumed
+	}
+	return nil
+}
+
+// ReadBody reads the whole body into Body. It is a convenience for handlers
+// that need the body in memory; large uploads should use BodyReader.
+func (r *Request) ReadBody() ([]byte, error) {
+	data, err := io.ReadAll(r.BodyReader)
+	r.Body = append(r.Body, data...)
+	return r.Body, err
+}
+
+// checkExpect looks at the Expect field once the headers are in. Only
+// 100-continue is understood, and only when a body follows; HTTP/1.0
+// requests have their expectations ignored.
+func (r *Request) checkExpect() error {
+	values := r.Headers.Values(expect)
+	if len(values) == 0 || r.RequestLine.HttpVersion == "1.0" {
+		return nil
+	}
+
+	for _, v := range values {
+		for _, e := range strings.Split(v, ",") {
+			e = strings.TrimSpace(e)
+			if e != "" && !strings.EqualFold(e, "100-continue") {
+				return fmt.Errorf("%w: %q", ErrExpectationFailed, e)
+			}
+		}
+	}
+
+	r.expectContinue = r.State.parseState != DONE
+	return nil
+}
+
+// ExpectsContinue reports whether the client sent Expect: 100-continue and
+// is waiting for an interim 100 Continue response before sending the body.
+func (r *Request) ExpectsContinue() bool {
+	return r.expectContinue
+}
+
+// OnBodyRead registers fn to run once, right before the first body bytes are
+// read from the connection. The server uses it to send 100 Continue only
+// when the handler actually wants the body.
+func (r *Request) OnBodyRead(fn func() error) {
+	r.onBodyRead = fn
+}
@@ -0,0 +1,114 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupportedTransferCoding is returned for a Transfer-Encoding other than
+// chunked. The server cannot find the end of such a body, so the request is
+// answered with 501 Not Implemented.
+var ErrUnsupportedTransferCoding = errors.New("unsupported transfer coding")
+
+// startBody picks the body framing once the headers are complete, following
+// RFC 9112 section 6. Anything a front-end proxy could read differently from
+// us (both Transfer-Encoding and Content-Length, conflicting lengths, a
+// coding we don't know) is rejected rather than guessed at, since that
+// disagreement is what request smuggling relies on. Requests without a body
+// go straight to DONE.
+func (r *Request) startBody() error {
+	hasTE := r.Headers.Has(transfEnc)
+	hasCL := r.Headers.Has(contLen)
+
+	switch {
+	case hasTE && hasCL:
+		return fmt.Errorf("both %s and %s present", transfEnc, contLen)
+	case hasTE && r.RequestLine.HttpVersion == "1.0":
+		return fmt.Errorf("%s in an HTTP/1.0 request", transfEnc)
+	case hasTE:
+		if err := checkChunked(r.Headers.Values(transfEnc)); err != nil {
+			return err
+		}
+		r.State.parseState = PARSING_CHUNK_SIZE
+		return nil
+	case !hasCL:
+		r.State.parseState = DONE
+		return nil
+	}
+
+	cLen, err := contentLength(r.Headers.Values(contLen))
+	if err != nil {
+		return err
+	}
+
+	if cLen == 0 {
+		r.State.parseState = DONE
+		return nil
+	}
+
+	if r.limits.MaxBodyBytes > 0 && int64(cLen) > r.limits.MaxBodyBytes {
+		return ErrBodyTooLarge
+	}
+
+	r.State.bodyRemaining = cLen
+	r.State.parseState = PARSING_BODY
+	return nil
+}
+
+// checkChunked makes sure the transfer codings, across all Transfer-Encoding
+// fields, are exactly "chunked": it is the only coding we can decode, and a
+// request body must end with it.
+func checkChunked(values []string) error {
+	chunked := 0
+	for _, v := range values {
+		for _, coding := range strings.Split(v, ",") {
+			name, _, _ := strings.Cut(coding, ";")
+			name = strings.TrimSpace(name)
+
+			switch {
+			case name == "":
+			case strings.EqualFold(name, "chunked"):
+				chunked++
+			default:
+				return fmt.Errorf("%w: %q", ErrUnsupportedTransferCoding, name)
+			}
+		}
+	}
+
+	if chunked != 1 {
+		return fmt.Errorf("invalid %s: %q", transfEnc, strings.Join(values, ", "))
+	}
+	return nil
+}
+
+// contentLength parses the Content-Length fields. Repeated fields, or a
+// comma-separated list, are only accepted when every value is the same.
+func contentLength(values []string) (uint64, error) {
+	var n uint64
+	seen := false
+
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			s = strings.TrimSpace(s)
+
+			// ParseUint would also take "+5" or "0x5" with other
+			// bases, so insist on plain digits.
+			if s == "" || strings.Trim(s, "0123456789") != "" {
+				return 0, fmt.Errorf("invalid %s: %q", contLen, v)
+			}
+
+			l, err := strconv.ParseUint(s, 10, 63)
+			if err != nil {
+				return 0, fmt.Errorf("invalid %s: %q", contLen, v)
+			}
+
+			if seen && l != n {
+				return 0, fmt.Errorf("conflicting %s values: %q", contLen, strings.Join(values, ", "))
+			}
+			n, seen = l, true
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,28 @@
+package request
+
+import "errors"
+
+// Limits caps how much of a request the parser is willing to buffer. A zero
+// field means no limit.
+type Limits struct {
+	MaxRequestLineBytes int
+	MaxHeaderBytes      int
+	MaxHeaderCount      int
+	MaxBodyBytes        int64
+}
+
+var DefaultLimits = Limits{
+	MaxRequestLineBytes: 8 << 10,
+	MaxHeaderBytes:      1 << 20,
+	MaxHeaderCount:      100,
+	MaxBodyBytes:        32 << 20,
+}
+
+// maxChunkLineBytes bounds a chunk-size line, extensions included.
+const maxChunkLineBytes = 4 << 10
+
+var (
+	ErrRequestLineTooLong = errors.New("request line too long")
+	ErrHeaderTooLarge     = errors.New("request header fields too large")
+	ErrBodyTooLarge       = errors.New("request body too large")
+)
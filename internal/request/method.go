@@ -0,0 +1,34 @@
+package request
+
+import "github.com/tsironi93/miniHttp/internal/headers"
+
+// Request methods defined by RFC 9110, plus PATCH from RFC 5789.
+const (
+	MethodGet     = "GET"
+	MethodHead    = "HEAD"
+	MethodPost    = "POST"
+	MethodPut     = "PUT"
+	MethodPatch   = "PATCH"
+	MethodDelete  = "DELETE"
+	MethodConnect = "CONNECT"
+	MethodOptions = "OPTIONS"
+	MethodTrace   = "TRACE"
+)
+
+// StandardMethods lists the methods above. The parser accepts any token as
+// a method; a server decides which of them it actually implements.
+var StandardMethods = []string{
+	MethodGet,
+	MethodHead,
+	MethodPost,
+	MethodPut,
+	MethodPatch,
+	MethodDelete,
+	MethodConnect,
+	MethodOptions,
+	MethodTrace,
+}
+
+func isValidMethod(method string) bool {
+	return headers.IsToken(method)
+}
@@ -1,8 +1,11 @@
 package request
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"strconv"
 	"strings"
 	"unicode"
@@ -12,7 +15,11 @@ import (
 
 const bufferSize int = 1024
 const crlf = "\r\n"
-const contLen = "content-length"
+const contLen = "Content-Length"
+const connection = "Connection"
+const transfEnc = "Transfer-Encoding"
+const hostField = "Host"
+const expect = "Expect"
 
 type parseState int
 
@@ -20,26 +27,93 @@ const (
 	INITIALIZED = iota
 	PARSING_HEADERS
 	PARSING_BODY
+	PARSING_CHUNK_SIZE
+	PARSING_CHUNK_DATA
+	PARSING_CHUNK_DATA_END
+	PARSING_TRAILERS
 	DONE
 )
 
 type Request struct {
 	RequestLine RequestLine
-	Headers     headers.Headers
-	Body        []byte
-	State       State
+	Headers     *headers.Headers
+	// Body is only filled in by ReadBody; handlers that can stream should
+	// read from BodyReader instead.
+	Body       []byte
+	BodyReader io.ReadCloser
+	Trailers   *headers.Headers
+	State      State
+	// Host is the effective host: the authority of an absolute-form or
+	// authority-form target, otherwise the Host field. Scheme is the
+	// scheme of an absolute-form target, otherwise "http".
+	Host   string
+	Scheme string
+	// Params holds the path parameters captured by a router, if any.
+	Params map[string]string
+
+	// bodyBuf holds body bytes that were decoded but not yet handed out
+	// by BodyReader.
+	bodyBuf []byte
+	limits  Limits
+
+	expectContinue bool
+	onBodyRead     func() error
 }
 
 type State struct {
-	parseState parseState
-	dataRead   uint64
-	dataParced uint64
+	parseState     parseState
+	dataRead       uint64
+	dataParced     uint64
+	headerBytes    int
+	headerCount    int
+	bodyRemaining  uint64
+	bodyRead       uint64
+	chunkRemaining uint64
 }
 
 type RequestLine struct {
 	HttpVersion   string
 	RequestTarget string
 	Method        string
+
+	// Path is the decoded target path with dot-segments removed; RawPath
+	// is the same path still escaped, so that a "%2F" inside a segment
+	// can be told apart from a separator.
+	Path     string
+	RawPath  string
+	RawQuery string
+	Query    url.Values
+}
+
+// Param returns the path parameter captured under name, or "" if there is
+// none.
+func (r *Request) Param(name string) string {
+	return r.Params[name]
+}
+
+// KeepAlive reports whether the client is willing to send another request on
+// the same connection. HTTP/1.1 clients are unless they send
+// Connection: close; HTTP/1.0 clients only if they send
+// Connection: keep-alive.
+func (r *Request) KeepAlive() bool {
+	if r.hasConnectionToken("close") {
+		return false
+	}
+	if r.RequestLine.HttpVersion == "1.0" {
+		return r.hasConnectionToken("keep-alive")
+	}
+	return true
+}
+
+func (r *Request) hasConnectionToken(token string) bool {
+	for _, v := range r.Headers.Values(connection) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func isKeywordCapitalized(key string) bool {
@@ -52,36 +126,163 @@ func isKeywordCapitalized(key string) bool {
 }
 
 func parceBody(r *Request, data []byte) int {
+	toCopy := data
+	if uint64(len(toCopy)) > r.State.bodyRemaining {
+		toCopy = data[:r.State.bodyRemaining]
+	}
+
+	r.bodyBuf = append(r.bodyBuf, toCopy...)
+	r.State.bodyRemaining -= uint64(len(toCopy))
+
+	if r.State.bodyRemaining == 0 {
+		r.State.parseState = DONE
+	}
+
+	return len(toCopy)
+}
+
+// parseChunked decodes a chunked body: a hex size line (with optional
+// extensions, which are ignored), the chunk data and its CRLF, repeated until
+// a zero-sized chunk, followed by an optional trailer section.
+func parseChunked(r *Request, data []byte) (int, error) {
 	bytesRead := 0
-	cLen := 0
-	remaining := 0
-	v, ok := r.Headers.Get(contLen)
-
-	if ok {
-		cLen, _ = strconv.Atoi(v)
-		remaining = cLen - len(r.Body)
-		if remaining <= 0 {
-			r.State.parseState = DONE
-			return bytesRead
+
+	for {
+		switch r.State.parseState {
+		case PARSING_CHUNK_SIZE:
+			idx := bytes.Index(data, []byte(crlf))
+			if idx == -1 {
+				if len(data) > maxChunkLineBytes {
+					return bytesRead, fmt.Errorf("chunk size line too long")
+				}
+				return bytesRead, nil
+			}
+
+			line := string(data[:idx])
+			size, err := parseChunkSize(line)
+			if err != nil {
+				return bytesRead, err
+			}
+
+			if r.limits.MaxBodyBytes > 0 &&
+				r.State.bodyRead+size > uint64(r.limits.MaxBodyBytes) {
+				return bytesRead, ErrBodyTooLarge
+			}
+			r.State.bodyRead += size
+
+			bytesRead += idx + 2
+			data = data[idx+2:]
+
+			if size == 0 {
+				r.State.parseState = PARSING_TRAILERS
+			} else {
+				r.State.chunkRemaining = size
+				r.State.parseState = PARSING_CHUNK_DATA
+			}
+
+		case PARSING_CHUNK_DATA:
+			if len(data) == 0 {
+				return bytesRead, nil
+			}
+
+			toCopy := data
+			if uint64(len(toCopy)) > r.State.chunkRemaining {
+				toCopy = data[:r.State.chunkRemaining]
+			}
+
+			r.bodyBuf = append(r.bodyBuf, toCopy...)
+			r.State.chunkRemaining -= uint64(len(toCopy))
+			bytesRead += len(toCopy)
+			data = data[len(toCopy):]
+
+			if r.State.chunkRemaining == 0 {
+				r.State.parseState = PARSING_CHUNK_DATA_END
+			}
+
+		case PARSING_CHUNK_DATA_END:
+			if len(data) < 2 {
+				return bytesRead, nil
+			}
+
+			if !bytes.HasPrefix(data, []byte(crlf)) {
+				return bytesRead, fmt.Errorf("missing CRLF after chunk data")
+			}
+
+			bytesRead += 2
+			data = data[2:]
+			r.State.parseState = PARSING_CHUNK_SIZE
+
+		case PARSING_TRAILERS:
+			n, done, err := r.Trailers.Parse(data)
+			if err != nil {
+				return bytesRead, err
+			}
+
+			if err := r.checkHeaderLimits(data, n, done); err != nil {
+				return bytesRead, err
+			}
+
+			bytesRead += n
+			data = data[n:]
+
+			if done {
+				r.State.parseState = DONE
+			}
+
+			if n == 0 || done {
+				return bytesRead, nil
+			}
+
+		default:
+			return bytesRead, nil
 		}
-	} else if !ok {
-		r.State.parseState = DONE
-		return bytesRead
 	}
+}
 
-	toCopy := data
-	if len(toCopy) > remaining {
-		toCopy = data[:remaining]
+// parseChunkSize reads the size from a chunk-size line. The size is strictly
+// 1*HEXDIG; whitespace is only allowed between it and a ";" extension.
+func parseChunkSize(line string) (uint64, error) {
+	digits := line
+	if ext := strings.IndexByte(line, ';'); ext != -1 {
+		digits = strings.TrimRight(line[:ext], " \t")
 	}
 
-	r.Body = append(r.Body, toCopy...)
-	bytesRead += len(toCopy)
+	if digits == "" || strings.Trim(digits, "0123456789abcdefABCDEF") != "" {
+		return 0, fmt.Errorf("invalid chunk size: %q", line)
+	}
 
-	if len(r.Body) == cLen {
-		r.State.parseState = DONE
+	size, err := strconv.ParseUint(digits, 16, 63)
+	if err != nil {
+		return 0, fmt.Errorf("invalid chunk size: %q", line)
+	}
+	return size, nil
+}
+
+// checkHeaderLimits accounts for the n bytes a header (or trailer) parse just
+// consumed from data, and for the incomplete line still waiting in the buffer.
+func (r *Request) checkHeaderLimits(data []byte, n int, done bool) error {
+	r.State.headerBytes += n
+
+	fields := bytes.Count(data[:n], []byte(crlf))
+	if done {
+		fields--
 	}
+	r.State.headerCount += fields
 
-	return bytesRead
+	if max := r.limits.MaxHeaderCount; max > 0 && r.State.headerCount > max {
+		return ErrHeaderTooLarge
+	}
+
+	if max := r.limits.MaxHeaderBytes; max > 0 {
+		pending := 0
+		if !done {
+			pending = len(data) - n
+		}
+		if r.State.headerBytes+pending > max {
+			return ErrHeaderTooLarge
+		}
+	}
+	return nil
 }
 
 func (r *Request) parse(data []byte) (int, error) {
@@ -90,22 +291,36 @@ func (r *Request) parse(data []byte) (int, error) {
 		return -1, fmt.Errorf("error: trying to read data in DONE state")
 	}
 
-	if r.State.parseState != INITIALIZED &&
-		r.State.parseState != PARSING_HEADERS &&
-		r.State.parseState != PARSING_BODY {
+	if r.State.parseState < INITIALIZED || r.State.parseState > DONE {
 		return -2, fmt.Errorf("error: unknown state")
 	}
 
 	totalBytes := 0
 
 	if r.State.parseState == INITIALIZED {
+		maxLine := r.limits.MaxRequestLineBytes
+
+		// RFC 9112 §2.2: empty lines received before the request line
+		// (often left over after a previous body) are ignored.
+		for bytes.HasPrefix(data, []byte(crlf)) {
+			totalBytes += len(crlf)
+			data = data[len(crlf):]
+		}
+
 		reqBytes, err := parseRequestLine(string(data), r)
 		if err != nil {
 			return -3, err
 		}
 
 		if reqBytes == 0 {
-			return 0, nil
+			if maxLine > 0 && len(data) > maxLine {
+				return 0, ErrRequestLineTooLong
+			}
+			return totalBytes, nil
+		}
+
+		if maxLine > 0 && reqBytes-len(crlf) > maxLine {
+			return 0, ErrRequestLineTooLong
 		}
 
 		totalBytes += reqBytes
@@ -113,27 +328,51 @@ func (r *Request) parse(data []byte) (int, error) {
 		data = data[reqBytes:]
 	}
 
-	for r.State.parseState == PARSING_HEADERS {
-
-		headBytes, done, err := r.Headers.Parse(data)
-		if err != nil {
-			return 0, err
-		}
-
-		if done {
-			r.State.parseState = PARSING_BODY
-		}
-
-		if headBytes == 0 && !done {
-			break
+	if r.State.parseState == PARSING_HEADERS {
+		for {
+			headBytes, done, err := r.Headers.Parse(data)
+			if err != nil {
+				return 0, err
+			}
+
+			if err := r.checkHeaderLimits(data, headBytes, done); err != nil {
+				return 0, err
+			}
+
+			totalBytes += headBytes
+			data = data[headBytes:]
+
+			if done {
+				if err := r.checkHost(); err != nil {
+					return 0, err
+				}
+				if err := r.startBody(); err != nil {
+					return 0, err
+				}
+				if err := r.checkExpect(); err != nil {
+					return 0, err
+				}
+				break
+			}
+
+			if headBytes == 0 {
+				break
+			}
 		}
 
-		totalBytes += headBytes
-		data = data[headBytes:]
+		// The body is left in the buffer for BodyReader to pull.
+		return totalBytes, nil
 	}
 
-	if r.State.parseState == PARSING_BODY {
+	switch r.State.parseState {
+	case PARSING_BODY:
 		totalBytes += parceBody(r, data)
+	case PARSING_CHUNK_SIZE, PARSING_CHUNK_DATA, PARSING_CHUNK_DATA_END, PARSING_TRAILERS:
+		chunkBytes, err := parseChunked(r, data)
+		totalBytes += chunkBytes
+		if err != nil {
+			return totalBytes, err
+		}
 	}
 	return totalBytes, nil
 }
@@ -154,68 +393,169 @@ func parseRequestLine(line string, r *Request) (int, error) {
 
 	method, target, version := parts[0], parts[1], parts[2]
 
-	if !isKeywordCapitalized(method) || !isKeywordCapitalized(version) {
-		return 0, fmt.Errorf("version or method are not capitalized: %s", line)
+	if !isKeywordCapitalized(version) {
+		return 0, fmt.Errorf("version is not capitalized: %s", line)
 	}
 
-	if method != "GET" && method != "POST" && method != "HEAD" {
-		return 0, fmt.Errorf("wrong method or non existand: %s", method)
+	if !isValidMethod(method) {
+		return 0, fmt.Errorf("Invalid method: %q", method)
 	}
 
-	if !strings.HasPrefix(target, "/") {
-		return 0, fmt.Errorf("Invalid target: %s", target)
+	httpVersion, err := parseVersion(version)
+	if err != nil {
+		return 0, err
 	}
 
-	if version != "HTTP/1.1" {
-		return 0, fmt.Errorf("Wrong HTTP version requested: %s", version)
+	if err := parseTarget(r, method, target); err != nil {
+		return 0, err
 	}
 
 	r.RequestLine.Method = method
 	r.RequestLine.RequestTarget = target
-	r.RequestLine.HttpVersion = "1.1"
+	r.RequestLine.HttpVersion = httpVersion
 
 	return idx + 2, nil
 }
 
-func RequestFromReader(reader io.Reader) (*Request, error) {
+// ErrVersionNotSupported is returned for a well-formed request line whose
+// HTTP major version is not 1.
+var ErrVersionNotSupported = errors.New("HTTP version not supported")
+
+// parseVersion checks an HTTP-version such as "HTTP/1.0" and returns the
+// version the request is served with: "1.0", or "1.1" for any later 1.x.
+func parseVersion(version string) (string, error) {
+	v, ok := strings.CutPrefix(version, "HTTP/")
+	if !ok || len(v) != 3 || v[1] != '.' ||
+		!unicode.IsDigit(rune(v[0])) || !unicode.IsDigit(rune(v[2])) {
+		return "", fmt.Errorf("Wrong HTTP version requested: %s", version)
+	}
 
-	buf := make([]byte, bufferSize)
-	readToIndex := 0
+	switch {
+	case v[0] != '1':
+		return "", fmt.Errorf("%w: %s", ErrVersionNotSupported, version)
+	case v[2] == '0':
+		return "1.0", nil
+	default:
+		return "1.1", nil
+	}
+}
 
-	r := &Request{
-		State: State{
-			parseState: INITIALIZED,
-			dataRead:   0,
-			dataParced: 0,
-		},
-		Headers: headers.NewHeaders(),
+// Reader reads consecutive requests from one connection. Bytes received past
+// the end of a request stay in the buffer and become the start of the next
+// one, so pipelined requests are not lost.
+type Reader struct {
+	Limits Limits
+
+	src         io.Reader
+	buf         []byte
+	readToIndex int
+	// body is the current request's own body reader. It is kept apart
+	// from BodyReader, which middleware may have wrapped, so the rest of
+	// the body can always be skipped on the wire.
+	body *bodyReader
+}
+
+func NewReader(src io.Reader) *Reader {
+	return &Reader{
+		Limits: DefaultLimits,
+		src:    src,
+		buf:    make([]byte, bufferSize),
 	}
+}
 
-	for r.State.parseState != DONE {
+// Buffered returns the number of bytes read from the connection that do not
+// belong to a request returned so far.
+func (rd *Reader) Buffered() int {
+	return rd.readToIndex
+}
 
-		if readToIndex == len(buf) {
-			newBuf := make([]byte, 2*len(buf))
-			copy(newBuf, buf)
-			buf = newBuf
-		}
+func (rd *Reader) consume(n int) {
+	copy(rd.buf, rd.buf[n:rd.readToIndex])
+	rd.readToIndex -= n
+}
 
-		n, err := reader.Read(buf[readToIndex:])
-		if err != nil {
-			return nil, err
-		}
+// WaitForRequest blocks until at least one byte of the next request is
+// available, without parsing anything. Servers use it to tell an idle
+// connection apart from a request that is being sent slowly.
+func (rd *Reader) WaitForRequest() error {
+	if rd.readToIndex > 0 {
+		return nil
+	}
+
+	n, err := rd.src.Read(rd.buf)
+	rd.readToIndex += n
+	if err != nil && n == 0 {
+		return err
+	}
+	return nil
+}
 
-		readToIndex += n
-		r.State.dataRead += uint64(n)
-		consumed, parseErr := r.parse(buf[:readToIndex])
+// advance feeds the buffered bytes to r's parser and reads more from the
+// connection when the parser cannot make progress with what it has.
+func (rd *Reader) advance(r *Request) error {
+	if rd.readToIndex > 0 {
+		consumed, parseErr := r.parse(rd.buf[:rd.readToIndex])
 		if parseErr != nil {
-			return nil, parseErr
+			return parseErr
 		}
 
 		if consumed > 0 {
-			copy(buf, buf[consumed:readToIndex])
-			readToIndex -= consumed
+			rd.consume(consumed)
 			r.State.dataParced += uint64(consumed)
+			return nil
 		}
 	}
+
+	if rd.readToIndex == len(rd.buf) {
+		newBuf := make([]byte, 2*len(rd.buf))
+		copy(newBuf, rd.buf)
+		rd.buf = newBuf
+	}
+
+	n, err := rd.src.Read(rd.buf[rd.readToIndex:])
+	rd.readToIndex += n
+	r.State.dataRead += uint64(n)
+	if err != nil && n == 0 {
+		return err
+	}
+	return nil
+}
+
+// ReadRequest parses the request line and headers of the next request and
+// returns as soon as they are complete. The body is read lazily through
+// BodyReader; whatever the caller leaves unread is discarded before the next
+// request is parsed.
+func (rd *Reader) ReadRequest() (*Request, error) {
+
+	if rd.body != nil {
+		if _, err := io.Copy(io.Discard, rd.body); err != nil {
+			return nil, err
+		}
+		rd.body = nil
+	}
+
+	r := &Request{
+		State: State{
+			parseState: INITIALIZED,
+			dataRead:   0,
+			dataParced: 0,
+		},
+		Headers:  headers.NewHeaders(),
+		Trailers: headers.NewHeaders(),
+		limits:   rd.Limits,
+	}
+
+	for r.State.parseState == INITIALIZED || r.State.parseState == PARSING_HEADERS {
+		if err := rd.advance(r); err != nil {
+			return nil, err
+		}
+	}
+
+	rd.body = &bodyReader{rd: rd, req: r}
+	r.BodyReader = rd.body
 	return r, nil
 }
+
+func RequestFromReader(reader io.Reader) (*Request, error) {
+	return NewReader(reader).ReadRequest()
+}
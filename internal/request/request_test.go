@@ -5,7 +5,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"io"
 	"math/rand"
-	// "strings"
+	"strings"
 	"testing"
 	"time"
 )
@@ -40,6 +40,19 @@ func wrapWithRandomChunks(s string) io.Reader {
 	}
 }
 
+// readRequest is readRequestWithLimits with the default limits.
+func readRequest(raw string) (*Request, error) {
+	return readRequestWithLimits(raw, DefaultLimits)
+}
+
+// readRequestWithLimits parses raw under the given limits through a
+// chunkReader, so the parser sees it arrive a few bytes at a time.
+func readRequestWithLimits(raw string, limits Limits) (*Request, error) {
+	rd := NewReader(&chunkReader{data: raw, numBytesPerRead: 3})
+	rd.Limits = limits
+	return rd.ReadRequest()
+}
+
 //	func TestRequestLineParse(t *testing.T) {
 //		// Test: Good GET Request line
 //		r, err := RequestFromReader(wrapWithRandomChunks("GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n"))
@@ -243,6 +256,8 @@ func TestBodyParsing(t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
+	_, err = r.ReadBody()
+	require.NoError(t, err)
 	assert.Equal(t, "hello world!\n", string(r.Body))
 
 	// Test: Body shorter than reported content length
@@ -255,6 +270,8 @@ func TestBodyParsing(t *testing.T) {
 		numBytesPerRead: 3,
 	}
 	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
 	require.Error(t, err)
 
 	// Test: Empty Body, 0 reported content length
@@ -268,6 +285,8 @@ func TestBodyParsing(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
+	_, err = r.ReadBody()
+	require.NoError(t, err)
 	assert.Len(t, r.Body, 0)
 
 	// Test: Empty Body, no reported content length
@@ -280,6 +299,8 @@ func TestBodyParsing(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
+	_, err = r.ReadBody()
+	require.NoError(t, err)
 	assert.Len(t, r.Body, 0)
 
 	// Test: No Content-Length but Body Exists
@@ -293,5 +314,557 @@ func TestBodyParsing(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
+	_, err = r.ReadBody()
+	require.NoError(t, err)
 	assert.Equal(t, "", string(r.Body))
 }
+
+func TestPipelinedRequests(t *testing.T) {
+	reader := NewReader(&chunkReader{
+		data: "POST /first HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello" +
+			"\r\n" +
+			"GET /second HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"\r\n" +
+			"GET /third HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"\r\n",
+		numBytesPerRead: 64,
+	})
+
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/first", r.RequestLine.RequestTarget)
+	_, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/third", r.RequestLine.RequestTarget)
+	assert.Equal(t, 0, reader.Buffered())
+
+	_, err = reader.ReadRequest()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestChunkedBodyParsing(t *testing.T) {
+	// Test: Standard chunked body
+	reader := &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n" +
+			"7\r\n world!\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	_, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!", string(r.Body))
+
+	// Test: Chunk extensions and trailers
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"a;name=value\r\n0123456789\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc123\r\n" +
+			"\r\n",
+		numBytesPerRead: 2,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	_, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(r.Body))
+	v, ok := r.Trailers.Get("x-checksum")
+	assert.True(t, ok)
+	assert.Equal(t, "abc123", v)
+
+	// Test: Chunked request followed by a pipelined request
+	rd := NewReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"3\r\nabc\r\n0\r\n\r\n" +
+			"GET /next HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"\r\n",
+		numBytesPerRead: 64,
+	})
+	r, err = rd.ReadRequest()
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(r.Body))
+	r, err = rd.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+
+	// Test: Invalid chunk size
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"zz\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.Error(t, err)
+
+	// Test: Chunk size must be bare hex digits
+	for _, size := range []string{" 5", "5 ", "\v5", "\t5", "+5", "0x5", "5x", ";ext"} {
+		reader = &chunkReader{
+			data: "POST /upload HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				size + "\r\nhello\r\n0\r\n\r\n",
+			numBytesPerRead: 3,
+		}
+		r, err = RequestFromReader(reader)
+		require.NoError(t, err)
+		_, err = r.ReadBody()
+		assert.Error(t, err, "chunk size %q", size)
+	}
+
+	// Test: Whitespace before a chunk extension is allowed
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5 \t;name=value\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+
+	// Test: Missing CRLF after chunk data
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"3\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.Error(t, err)
+}
+
+func TestStreamingBody(t *testing.T) {
+	// Test: Headers are returned before the body is read
+	rd := NewReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 26\r\n" +
+			"\r\n" +
+			"abcdefghijklmnopqrstuvwxyz" +
+			"GET /next HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"\r\n",
+		numBytesPerRead: 4,
+	})
+	r, err := rd.ReadRequest()
+	require.NoError(t, err)
+	require.NotNil(t, r.BodyReader)
+	assert.Len(t, r.Body, 0)
+
+	part := make([]byte, 10)
+	n, err := io.ReadFull(r.BodyReader, part)
+	require.NoError(t, err)
+	assert.Equal(t, "abcdefghij", string(part[:n]))
+
+	rest, err := io.ReadAll(r.BodyReader)
+	require.NoError(t, err)
+	assert.Equal(t, "klmnopqrstuvwxyz", string(rest))
+
+	// Test: Unread body is skipped before the next request
+	rd = NewReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello" +
+			"GET /next HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"\r\n",
+		numBytesPerRead: 4,
+	})
+	_, err = rd.ReadRequest()
+	require.NoError(t, err)
+	r, err = rd.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+
+	// Test: Close discards the rest of the body
+	r, err = RequestFromReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	})
+	require.NoError(t, err)
+	require.NoError(t, r.BodyReader.Close())
+}
+
+func TestRequestLimits(t *testing.T) {
+	limits := Limits{
+		MaxRequestLineBytes: 32,
+		MaxHeaderBytes:      64,
+		MaxHeaderCount:      3,
+		MaxBodyBytes:        8,
+	}
+
+	// Test: Request line within limits
+	_, err := readRequestWithLimits("GET /ok HTTP/1.1\r\nHost: localhost\r\n\r\n", limits)
+	require.NoError(t, err)
+
+	// Test: Request line too long
+	_, err = readRequestWithLimits("GET /"+strings.Repeat("x", 64)+" HTTP/1.1\r\nHost: localhost\r\n\r\n", limits)
+	require.ErrorIs(t, err, ErrRequestLineTooLong)
+
+	// Test: Header block too large
+	_, err = readRequestWithLimits("GET / HTTP/1.1\r\nX-Big: "+strings.Repeat("x", 100)+"\r\n\r\n", limits)
+	require.ErrorIs(t, err, ErrHeaderTooLarge)
+
+	// Test: Too many header fields
+	_, err = readRequestWithLimits("GET / HTTP/1.1\r\nA: 1\r\nB: 2\r\nC: 3\r\nD: 4\r\n\r\n", limits)
+	require.ErrorIs(t, err, ErrHeaderTooLarge)
+
+	// Test: Content-Length over the body limit
+	_, err = readRequestWithLimits("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 9\r\n\r\n123456789", limits)
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Chunked body over the body limit
+	r, err := readRequestWithLimits("POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"5\r\n12345\r\n5\r\n67890\r\n0\r\n\r\n", limits)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Zero disables a limit
+	r, err = readRequestWithLimits(
+		"POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 12\r\n\r\nhello world!", Limits{})
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!", string(r.Body))
+}
+
+func TestRequestTarget(t *testing.T) {
+	// Test: Path and query are split and decoded
+	r, err := readRequest("GET /files/a%20b%2Fc?q=go+lang&tag=a&tag=b%26c HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "/files/a b/c", r.RequestLine.Path)
+	assert.Equal(t, "/files/a%20b%2Fc", r.RequestLine.RawPath)
+	assert.Equal(t, "q=go+lang&tag=a&tag=b%26c", r.RequestLine.RawQuery)
+	assert.Equal(t, "go lang", r.RequestLine.Query.Get("q"))
+	assert.Equal(t, []string{"a", "b&c"}, r.RequestLine.Query["tag"])
+
+	// Test: Target without a query
+	r, err = readRequest("GET /video HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "/video", r.RequestLine.Path)
+	assert.Empty(t, r.RequestLine.Query)
+
+	// Test: Dot-segments are removed
+	r, err = readRequest("GET /a/./b/../c/ HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "/a/c/", r.RequestLine.Path)
+
+	// Test: Dot-segments cannot climb above the root
+	r, err = readRequest("GET /../../etc/passwd HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "/etc/passwd", r.RequestLine.Path)
+
+	// Test: Encoded dot-segments are removed too
+	r, err = readRequest("GET /static/%2e%2e/secret/.. HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "/", r.RequestLine.Path)
+
+	// Test: Escaped slashes don't form dot-segments
+	r, err = readRequest("GET /public%2F..%2Fadmin HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "/public%2F..%2Fadmin", r.RequestLine.RawPath)
+	assert.Equal(t, "/public/../admin", r.RequestLine.Path)
+
+	// Test: Escaped slash stays inside its segment
+	r, err = readRequest("GET /users/a%2Fb/../c HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "/users/c", r.RequestLine.RawPath)
+	r, err = readRequest("GET /users/a%2Fb HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "/users/a%2Fb", r.RequestLine.RawPath)
+
+	// Test: Malformed percent-encoding in the path
+	_, err = readRequest("GET /bad%zz HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.Error(t, err)
+
+	// Test: Malformed percent-encoding in the query
+	_, err = readRequest("GET /ok?x=%4 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.Error(t, err)
+}
+
+func TestRequestMethods(t *testing.T) {
+	// Test: Standard methods
+	for _, method := range StandardMethods {
+		target := "/"
+		if method == MethodConnect {
+			target = "localhost:443"
+		}
+		r, err := readRequest(method + " " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		require.NoError(t, err, method)
+		assert.Equal(t, method, r.RequestLine.Method)
+	}
+
+	// Test: Extension method token
+	r, err := readRequest("PURGE /cache HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "PURGE", r.RequestLine.Method)
+
+	// Test: Asterisk-form with OPTIONS
+	r, err = readRequest("OPTIONS * HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "*", r.RequestLine.RequestTarget)
+	assert.Equal(t, "*", r.RequestLine.Path)
+
+	// Test: Asterisk-form with another method
+	_, err = readRequest("GET * HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.Error(t, err)
+
+	// Test: Method that is not a token
+	_, err = readRequest("GE(T / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.Error(t, err)
+}
+
+func TestHTTPVersions(t *testing.T) {
+	// Test: HTTP/1.1 is kept alive unless the client asks to close
+	r, err := readRequest("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "1.1", r.RequestLine.HttpVersion)
+	assert.True(t, r.KeepAlive())
+
+	// Test: HTTP/1.0 closes by default
+	r, err = readRequest("GET / HTTP/1.0\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.RequestLine.HttpVersion)
+	assert.False(t, r.KeepAlive())
+
+	// Test: HTTP/1.0 with Connection: keep-alive
+	r, err = readRequest("GET / HTTP/1.0\r\nConnection: Keep-Alive\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, r.KeepAlive())
+
+	// Test: Later 1.x minor versions are served as HTTP/1.1
+	r, err = readRequest("GET / HTTP/1.2\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "1.1", r.RequestLine.HttpVersion)
+
+	// Test: Other major versions are not supported
+	_, err = readRequest("GET / HTTP/2.0\r\nHost: localhost\r\n\r\n")
+	require.ErrorIs(t, err, ErrVersionNotSupported)
+
+	// Test: Malformed version
+	_, err = readRequest("GET / HTTP/1\r\nHost: localhost\r\n\r\n")
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrVersionNotSupported)
+}
+
+func TestRequestHost(t *testing.T) {
+	// Test: Host field gives the effective host
+	r, err := readRequest("GET / HTTP/1.1\r\nHost: example.com:8080\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com:8080", r.Host)
+	assert.Equal(t, "http", r.Scheme)
+
+	// Test: Missing Host in HTTP/1.1
+	_, err = readRequest("GET / HTTP/1.1\r\nAccept: */*\r\n\r\n")
+	require.Error(t, err)
+
+	// Test: Missing Host is fine in HTTP/1.0
+	r, err = readRequest("GET / HTTP/1.0\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "", r.Host)
+
+	// Test: Multiple Host fields
+	_, err = readRequest("GET / HTTP/1.1\r\nHost: a.com\r\nHost: b.com\r\n\r\n")
+	require.Error(t, err)
+
+	// Test: Invalid Host value
+	_, err = readRequest("GET / HTTP/1.1\r\nHost: user@evil.com\r\n\r\n")
+	require.Error(t, err)
+
+	// Test: IPv6 literal with a port
+	r, err = readRequest("GET / HTTP/1.1\r\nHost: [::1]:8080\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "[::1]:8080", r.Host)
+
+	// Test: Absolute-form target overrides the Host field
+	r, err = readRequest("GET HTTPS://example.com/a/b?x=1 HTTP/1.1\r\nHost: other.com\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", r.Host)
+	assert.Equal(t, "https", r.Scheme)
+	assert.Equal(t, "/a/b", r.RequestLine.Path)
+	assert.Equal(t, "1", r.RequestLine.Query.Get("x"))
+
+	// Test: Absolute-form target without a path
+	r, err = readRequest("GET http://example.com HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "/", r.RequestLine.Path)
+
+	// Test: Absolute-form target with an unsupported scheme
+	_, err = readRequest("GET ftp://example.com/ HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	require.Error(t, err)
+
+	// Test: Authority-form target for CONNECT
+	r, err = readRequest("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com:443", r.Host)
+
+	// Test: Authority-form target needs a port
+	_, err = readRequest("CONNECT example.com HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	require.Error(t, err)
+
+	// Test: Authority-form target with another method
+	_, err = readRequest("GET example.com:443 HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	require.Error(t, err)
+}
+
+func TestBodyFramingRules(t *testing.T) {
+	// Test: Transfer-Encoding together with Content-Length
+	_, err := readRequest("POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\nContent-Length: 5\r\n\r\nhello")
+	require.Error(t, err)
+
+	// Test: Content-Length before Transfer-Encoding is rejected too
+	_, err = readRequest("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nTransfer-Encoding: chunked\r\n\r\nhello")
+	require.Error(t, err)
+
+	// Test: Repeated Content-Length fields with differing values
+	_, err = readRequest("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nContent-Length: 10\r\n\r\nhello")
+	require.Error(t, err)
+
+	// Test: Content-Length list with differing values
+	_, err = readRequest("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5, 10\r\n\r\nhello")
+	require.Error(t, err)
+
+	// Test: Repeated identical Content-Length values are accepted
+	r, err := readRequest("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5, 5\r\nContent-Length: 5\r\n\r\nhello")
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+
+	// Test: Non-numeric Content-Length
+	_, err = readRequest("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: abc\r\n\r\nhello")
+	require.Error(t, err)
+
+	// Test: Negative Content-Length
+	_, err = readRequest("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: -5\r\n\r\nhello")
+	require.Error(t, err)
+
+	// Test: Signed Content-Length
+	_, err = readRequest("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: +5\r\n\r\nhello")
+	require.Error(t, err)
+
+	// Test: Empty Content-Length
+	_, err = readRequest("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: \r\n\r\nhello")
+	require.Error(t, err)
+
+	// Test: Content-Length that overflows
+	_, err = readRequest("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 99999999999999999999\r\n\r\nhello")
+	require.Error(t, err)
+
+	// Test: Unknown transfer coding
+	_, err = readRequest("POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: gzip, chunked\r\n\r\nhello")
+	require.ErrorIs(t, err, ErrUnsupportedTransferCoding)
+
+	// Test: Unknown transfer coding in a second field
+	_, err = readRequest("POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\nTransfer-Encoding: identity\r\n\r\nhello")
+	require.ErrorIs(t, err, ErrUnsupportedTransferCoding)
+
+	// Test: Chunked applied twice
+	_, err = readRequest("POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked, chunked\r\n\r\nhello")
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrUnsupportedTransferCoding)
+
+	// Test: Transfer-Encoding in an HTTP/1.0 request
+	_, err = RequestFromReader(strings.NewReader(
+		"POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"))
+	require.Error(t, err)
+
+	// Test: Chunked coding is case-insensitive
+	r, err = readRequest("POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: Chunked\r\n\r\n" +
+		"5\r\nhello\r\n0\r\n\r\n")
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+}
+
+func TestExpectContinue(t *testing.T) {
+	// Test: Hook runs once, before the body is read
+	r, err := readRequest("POST / HTTP/1.1\r\nHost: localhost\r\nExpect: 100-Continue\r\nContent-Length: 5\r\n\r\nhello")
+	require.NoError(t, err)
+	assert.True(t, r.ExpectsContinue())
+	calls := 0
+	r.OnBodyRead(func() error {
+		calls++
+		return nil
+	})
+	assert.Equal(t, 0, calls)
+	_, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, "hello", string(r.Body))
+
+	// Test: Body left unread while the client waits
+	r, err = readRequest("POST / HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\nhello")
+	require.NoError(t, err)
+	r.OnBodyRead(func() error { return nil })
+	require.ErrorIs(t, r.BodyReader.Close(), ErrBodyNotConsumed)
+
+	// Test: Nothing to wait for without a body
+	r, err = readRequest("POST / HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\n\r\nhello")
+	require.NoError(t, err)
+	assert.False(t, r.ExpectsContinue())
+
+	// Test: Unknown expectation
+	_, err = readRequest("POST / HTTP/1.1\r\nHost: localhost\r\nExpect: something-else\r\nContent-Length: 5\r\n\r\nhello")
+	require.ErrorIs(t, err, ErrExpectationFailed)
+
+	// Test: HTTP/1.0 expectations are ignored
+	r, err = RequestFromReader(strings.NewReader(
+		"POST / HTTP/1.0\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	assert.False(t, r.ExpectsContinue())
+}
@@ -0,0 +1,198 @@
+package request
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// parseTarget handles the four request-target forms of RFC 9112 section 3.2:
+// origin-form ("/path?query"), absolute-form ("http://host/path"),
+// authority-form ("host:port", CONNECT only) and asterisk-form ("*", OPTIONS
+// only).
+func parseTarget(r *Request, method, target string) error {
+	rl := &r.RequestLine
+
+	switch {
+	case method == MethodConnect:
+		if !isValidHost(target) || !strings.Contains(target, ":") {
+			return fmt.Errorf("Invalid CONNECT target: %s", target)
+		}
+		r.Host = target
+		return nil
+	case target == "*":
+		// The asterisk-form asks about the server as a whole rather
+		// than one resource, which only makes sense for OPTIONS.
+		if method != MethodOptions {
+			return fmt.Errorf("Invalid target for %s: %s", method, target)
+		}
+		rl.Path, rl.RawPath = target, target
+		return nil
+	case strings.HasPrefix(target, "/"):
+		return parseOrigin(rl, target)
+	}
+
+	scheme, rest, ok := strings.Cut(target, "://")
+	scheme = strings.ToLower(scheme)
+	if !ok || (scheme != "http" && scheme != "https") {
+		return fmt.Errorf("Invalid target: %s", target)
+	}
+
+	authority, path := rest, "/"
+	if i := strings.IndexAny(rest, "/?"); i != -1 {
+		authority, path = rest[:i], rest[i:]
+		if path[0] == '?' {
+			path = "/" + path
+		}
+	}
+
+	if authority == "" || !isValidHost(authority) {
+		return fmt.Errorf("Invalid target authority: %s", target)
+	}
+
+	r.Host = authority
+	r.Scheme = scheme
+	return parseOrigin(rl, path)
+}
+
+// parseOrigin splits an origin-form target into its raw path and query,
+// removes dot-segments from the raw path and decodes both. Dot-segments are
+// found before decoding, so an escaped "/" such as in "a%2F..%2Fb" stays part
+// of its segment instead of turning into a separator.
+func parseOrigin(rl *RequestLine, target string) error {
+	rawPath, rawQuery, _ := strings.Cut(target, "?")
+	if _, err := url.PathUnescape(rawPath); err != nil {
+		return fmt.Errorf("Invalid target path: %s", target)
+	}
+
+	rawPath = removeDotSegments(rawPath)
+	path, _ := url.PathUnescape(rawPath)
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return fmt.Errorf("Invalid target query: %s", target)
+	}
+
+	rl.Path = path
+	rl.RawPath = rawPath
+	rl.RawQuery = rawQuery
+	rl.Query = query
+	return nil
+}
+
+// checkHost applies the Host rules of RFC 9112 section 3.2 once the headers
+// are in: an HTTP/1.1 request carries exactly one Host field, HTTP/1.0 at most
+// one, and the value must be a valid authority. A host taken from an
+// absolute-form target wins over the field.
+func (r *Request) checkHost() error {
+	values := r.Headers.Values(hostField)
+
+	switch {
+	case len(values) > 1:
+		return fmt.Errorf("multiple Host fields")
+	case len(values) == 0 && r.RequestLine.HttpVersion != "1.0":
+		return fmt.Errorf("missing Host field")
+	case len(values) == 1 && values[0] != "" && !isValidHost(values[0]):
+		return fmt.Errorf("Invalid Host: %q", values[0])
+	}
+
+	if r.Host == "" && len(values) == 1 {
+		r.Host = values[0]
+	}
+	if r.Scheme == "" {
+		r.Scheme = "http"
+	}
+	return nil
+}
+
+// isValidHost reports whether s is a uri-host with an optional port. User
+// information is not allowed in HTTP authorities.
+func isValidHost(s string) bool {
+	hostname, port := s, ""
+	if strings.HasPrefix(s, "[") {
+		end := strings.IndexByte(s, ']')
+		if end == -1 {
+			return false
+		}
+		hostname, port = s[1:end], s[end+1:]
+		if port != "" && port[0] != ':' {
+			return false
+		}
+		port = strings.TrimPrefix(port, ":")
+		if !isIPLiteral(hostname) {
+			return false
+		}
+	} else {
+		if i := strings.LastIndexByte(s, ':'); i != -1 {
+			hostname, port = s[:i], s[i+1:]
+		}
+		if hostname == "" {
+			return false
+		}
+		for _, c := range hostname {
+			if !isRegNameChar(c) {
+				return false
+			}
+		}
+	}
+
+	for _, c := range port {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isRegNameChar(c rune) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.ContainsRune("-._~%!$&'()*+,;=", c)
+}
+
+func isIPLiteral(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case c >= '0' && c <= '9', c >= 'a' && c <= 'f', c >= 'A' && c <= 'F':
+		case c == ':' || c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// removeDotSegments resolves "." and ".." segments of a raw path as described
+// in RFC 3986 section 5.2.4, where a dot may also be sent as "%2E". A ".." at
+// the root is dropped, so the result never climbs above "/".
+func removeDotSegments(path string) string {
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+
+	out := make([]string, 0, len(segments))
+	for i, seg := range segments {
+		last := i == len(segments)-1
+
+		switch strings.ReplaceAll(strings.ReplaceAll(seg, "%2e", "."), "%2E", ".") {
+		case ".":
+		case "..":
+			if len(out) > 0 {
+				out = out[:len(out)-1]
+			}
+		default:
+			out = append(out, seg)
+			continue
+		}
+
+		// A trailing "." or ".." still names a directory.
+		if last {
+			out = append(out, "")
+		}
+	}
+
+	return "/" + strings.Join(out, "/")
+}
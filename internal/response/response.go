@@ -2,6 +2,7 @@ package response
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -29,13 +30,19 @@ const (
 	Video
 )
 
-type StatusCode int
+// maxBufferedBody is how much body Write holds back so that Content-Length
+// can be computed. Past that the headers go out and the body is streamed.
+const maxBufferedBody = 4 << 10
 
-const (
-	StatusOK                  StatusCode = 200
-	StatusBadRequest          StatusCode = 400
-	StatusInternalServerError StatusCode = 500
-)
+// ErrInvalidStatusCode is returned when StatusCode can't be sent as the final
+// status: it doesn't have three digits, or it is an interim 1xx code, which
+// only WriteInformational sends.
+var ErrInvalidStatusCode = errors.New("invalid status code")
+
+// ErrContentLength is returned by a write that would take the body past the
+// Content-Length the handler set, or by WriteResponse when the buffered body
+// is already longer than that. Nothing of it is sent.
+var ErrContentLength = errors.New("body longer than the declared Content-Length")
 
 type writerState int
 
@@ -48,11 +55,30 @@ const (
 
 type Writer struct {
 	StatusCode StatusCode
-	Headers    map[string]string
+	Headers    *headers.Headers
 	Body       bytes.Buffer
 	state      writerState
 	Chunked    bool
-	Out       net.Conn
+	Out        net.Conn
+
+	keepAlive       bool
+	head            bool
+	version         string
+	streaming       bool
+	finishing       bool
+	trailersPending bool
+	headerHooks     []func(*Writer)
+
+	// contentLength is the length the body is framed by, or -1 when it
+	// is chunked, close-delimited or not sent; sent counts the body bytes
+	// written against it.
+	contentLength int64
+	sent          int64
+
+	// newEnc is the encoder requested with SetEncoder; enc is the one
+	// the body is currently streamed through.
+	newEnc func(dst io.Writer) io.WriteCloser
+	enc    io.WriteCloser
 }
 
 func NewWriter(out net.Conn) *Writer {
@@ -61,67 +87,385 @@ func NewWriter(out net.Conn) *Writer {
 		StatusCode: StatusOK,
 		Headers:    h,
 		Chunked:    false,
-		Out:       out,
+		Out:        out,
+		version:    "1.1",
+
+		contentLength: -1,
 	}
 }
 
+// Write adds p to the response body. Small bodies are buffered so they can be
+// sent with a Content-Length; once the buffer outgrows maxBufferedBody the
+// status line and headers are sent and the rest of the body goes straight to
+// the connection, chunked unless the handler set Content-Length itself.
 func (w *Writer) Write(p []byte) (int, error) {
-	return w.Body.Write(p)
+	switch w.state {
+	case stateInit, stateStatusWritten:
+		n, _ := w.Body.Write(p)
+		if w.Body.Len() > maxBufferedBody {
+			if err := w.Flush(); err != nil {
+				return 0, err
+			}
+		}
+		return n, nil
+	case stateHeadersWritten:
+		return w.writeBody(p)
+	default:
+		return 0, fmt.Errorf("Write called after the body was finished")
+	}
 }
 
 func (w *Writer) WriteString(s string) (int, error) {
-	return w.Body.WriteString(s)
+	return w.Write([]byte(s))
+}
+
+// Flush sends the status line and headers if they have not gone out yet,
+// followed by whatever body is buffered. A response flushed before it is
+// complete has no known length, so it is sent chunked unless the handler set
+// Content-Length.
+func (w *Writer) Flush() error {
+	w.streaming = true
+
+	if w.state == stateInit {
+		if err := w.WriteStatusLine(); err != nil {
+			return err
+		}
+	}
+
+	if w.state == stateStatusWritten {
+		if err := w.WriteHeaders(); err != nil {
+			return err
+		}
+	}
+
+	if w.state != stateHeadersWritten {
+		return nil
+	}
+
+	if w.Body.Len() > 0 {
+		_, err := w.writeBody(w.Body.Bytes())
+		w.Body.Reset()
+		if err != nil {
+			return err
+		}
+	}
+
+	if f, ok := w.enc.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// SetRequestMethod tells the writer which method the response answers. For
+// HEAD the status line and headers are the ones GET would get, including
+// Content-Length, but no body bytes are sent.
+func (w *Writer) SetRequestMethod(method string) {
+	w.head = method == "HEAD"
+}
+
+// SetHTTPVersion sets the protocol version of the response, "1.1" or "1.0",
+// to match the request. HTTP/1.0 has no chunked encoding, so a streamed
+// response without Content-Length is ended by closing the connection.
+func (w *Writer) SetHTTPVersion(version string) {
+	w.version = version
+}
+
+// sendsBody reports whether body bytes go on the wire at all.
+func (w *Writer) sendsBody() bool {
+	return bodyAllowed(w.StatusCode) && !w.head
+}
+
+// writeBody sends p as (part of) the body, encoded if an encoder is active
+// and framed the way the headers announced.
+func (w *Writer) writeBody(p []byte) (int, error) {
+	if !w.sendsBody() {
+		return len(p), nil
+	}
+
+	if w.enc != nil {
+		return w.enc.Write(p)
+	}
+	return w.writeFramed(p)
+}
+
+// writeFramed sends p as a chunk or as is, depending on the framing.
+func (w *Writer) writeFramed(p []byte) (int, error) {
+	if !w.isChunked() {
+		if w.contentLength >= 0 && w.sent+int64(len(p)) > w.contentLength {
+			return 0, ErrContentLength
+		}
+		n, err := w.Out.Write(p)
+		w.sent += int64(n)
+		return n, err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	sizeLine := strconv.FormatInt(int64(len(p)), 16) + CRLF
+	chunk := net.Buffers{[]byte(sizeLine), p, []byte(CRLF)}
+	if _, err := chunk.WriteTo(w.Out); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// framedBody is where a streaming encoder writes its output.
+type framedBody struct {
+	w *Writer
+}
+
+func (b framedBody) Write(p []byte) (int, error) {
+	return b.w.writeFramed(p)
+}
+
+// SetEncoder makes the writer pass the body through an encoder made by
+// newEnc, e.g. a gzip.Writer, before framing it. It must be called before the
+// headers go out, typically from an OnWriteHeader hook, and the caller sets
+// Content-Encoding. A body that is complete by then is encoded in memory and
+// keeps a Content-Length; otherwise it is encoded as it is written and sent
+// chunked.
+func (w *Writer) SetEncoder(newEnc func(dst io.Writer) io.WriteCloser) {
+	w.newEnc = newEnc
+}
+
+// ContentLength returns the body length if it is known before the headers go
+// out: the Content-Length set by the handler, or the size of the buffered body
+// when the whole response is being written in one go.
+func (w *Writer) ContentLength() (int64, bool) {
+	if v, ok := w.Headers.Get(ContLen); ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		return n, err == nil
+	}
+	if w.finishing && !w.streaming {
+		return int64(w.Body.Len()), true
+	}
+	return 0, false
+}
+
+// startEncoder applies the encoder requested with SetEncoder.
+func (w *Writer) startEncoder() error {
+	newEnc := w.newEnc
+	w.newEnc = nil
+	if !bodyAllowed(w.StatusCode) {
+		return nil
+	}
+
+	w.Headers.Del(ContLen)
+
+	if w.finishing && !w.streaming {
+		var out bytes.Buffer
+		enc := newEnc(&out)
+		if _, err := enc.Write(w.Body.Bytes()); err != nil {
+			return err
+		}
+		if err := enc.Close(); err != nil {
+			return err
+		}
+		w.Body.Reset()
+		w.Body.Write(out.Bytes())
+		return nil
+	}
+
+	w.Chunked = true
+	if !w.head {
+		w.enc = newEnc(framedBody{w})
+	}
+	return nil
+}
+
+// closeEncoder writes out whatever the encoder still holds.
+func (w *Writer) closeEncoder() error {
+	if w.enc == nil {
+		return nil
+	}
+	enc := w.enc
+	w.enc = nil
+	return enc.Close()
 }
 
+// WriteResponse sends whatever part of the response has not gone out yet and
+// ends the body.
 func (w *Writer) WriteResponse() error {
-	if err := w.WriteStatusLine(); err != nil {
-		return err
+	if w.state < stateHeadersWritten {
+		w.finishing = true
 	}
 
-	if err := w.WriteHeaders(); err != nil {
-		return err
+	if w.state == stateInit {
+		if err := w.WriteStatusLine(); err != nil {
+			return err
+		}
 	}
 
-	if _, err := w.WriteBody(); err != nil {
-		return err
+	if w.state == stateStatusWritten {
+		if err := w.WriteHeaders(); err != nil {
+			return err
+		}
+	}
+
+	if w.state == stateHeadersWritten {
+		if _, err := w.WriteBody(); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
 func WriteBadRequestResponse(out net.Conn) {
+	WriteStatusResponse(out, StatusBadRequest)
+}
+
+// WriteStatusResponse sends a complete response with a plain text body naming
+// the status, and asks the client to close the connection.
+func WriteStatusResponse(out net.Conn, code StatusCode) {
 	errWriter := NewWriter(out)
-	errWriter.StatusCode = StatusBadRequest
-	errWriter.Body.Reset()
-	errWriter.Body.Write([]byte("Bad Request\n"))
+	errWriter.SetError(code)
 	errWriter.WriteResponse()
 }
 
-func GetDefaultHeaders() headers.Headers {
+// SetError replaces the pending response with a plain text one naming code.
+func (w *Writer) SetError(code StatusCode) {
+	w.StatusCode = code
+	w.Headers.Set(ContType, "text/plain")
+	w.Body.Reset()
+	w.Body.WriteString(StatusText(code) + "\n")
+}
+
+func GetDefaultHeaders() *headers.Headers {
 	h := headers.NewHeaders()
-	h[Conn] = "close"
-	h[ContType] = HTML
+	h.Set(ContType, HTML)
 
 	return h
 }
 
+// SetKeepAlive tells the writer whether the server is willing to keep the
+// connection open after this response. Writers start out with keep-alive off.
+func (w *Writer) SetKeepAlive(keepAlive bool) {
+	w.keepAlive = keepAlive
+}
+
+// KeepAlive reports whether the connection may carry another request once
+// this response is complete. A handler can opt out by setting Connection: close.
+func (w *Writer) KeepAlive() bool {
+	if !w.keepAlive {
+		return false
+	}
+	v, _ := w.Headers.Get(Conn)
+	return !strings.EqualFold(v, "close")
+}
+
+func (w *Writer) isChunked() bool {
+	if !bodyAllowed(w.StatusCode) || w.version == "1.0" {
+		return false
+	}
+	return w.wantsChunked()
+}
+
+func (w *Writer) wantsChunked() bool {
+	v, _ := w.Headers.Get(TransfEnc)
+	return w.Chunked || strings.EqualFold(v, "chunked")
+}
+
+// Finish writes whatever part of the response the handler left out, so the
+// message is properly framed on the wire. It reports whether the connection
+// can be reused for the next request, which it can't if the body fell short
+// of the Content-Length the handler set: the client would take the start of
+// the next response for the rest of this one.
+func (w *Writer) Finish() bool {
+	if err := w.WriteResponse(); err != nil {
+		return false
+	}
+
+	if w.contentLength >= 0 && w.sent < w.contentLength {
+		return false
+	}
+
+	if w.trailersPending {
+		if _, err := io.WriteString(w.Out, CRLF); err != nil {
+			return false
+		}
+		w.trailersPending = false
+	}
+
+	return w.KeepAlive()
+}
+
+// Written reports whether the status line has already gone out, after which
+// the status and headers can no longer change.
+func (w *Writer) Written() bool {
+	return w.state != stateInit
+}
+
+// OnWriteHeader registers fn to run right before the status line is sent,
+// while StatusCode and Headers can still be changed. Hooks run in the order
+// they were registered.
+func (w *Writer) OnWriteHeader(fn func(*Writer)) {
+	w.headerHooks = append(w.headerHooks, fn)
+}
+
+// WriteInformational sends an interim 1xx response with the fields in h,
+// which may be nil, ahead of the final response. Any number of them can be
+// sent while the writer is still in stateInit; they leave the state alone, so
+// the final status line, headers and body follow in the usual order. HTTP/1.0
+// clients don't understand interim responses, so nothing is sent to them.
+func (w *Writer) WriteInformational(code StatusCode, h *headers.Headers) error {
+	if w.state != stateInit {
+		return fmt.Errorf("WriteInformational called after the final status line")
+	}
+
+	// 101 ends HTTP/1.1 on the connection, so it is not an interim
+	// response in this sense.
+	if !code.IsInformational() || code == StatusSwitchingProtocols {
+		return fmt.Errorf("invalid informational status code %d", code)
+	}
+
+	if w.version == "1.0" {
+		return nil
+	}
+
+	statusLine := fmt.Sprintf("HTTP/%s %03d %s"+CRLF, w.version, code, StatusText(code))
+	_, err := io.WriteString(w.Out, statusLine+fieldBlock(h))
+	return err
+}
+
+// WriteEarlyHints sends a 103 Early Hints response with one Link field per
+// entry in links, e.g. "</style.css>; rel=preload; as=style", so the client
+// can start fetching them while the final response is still being prepared.
+// It may be called several times before the final status line.
+func (w *Writer) WriteEarlyHints(links ...string) error {
+	h := headers.NewHeaders()
+	for _, link := range links {
+		h.Add("Link", link)
+	}
+	return w.WriteInformational(StatusEarlyHints, h)
+}
+
 func (w *Writer) WriteStatusLine() error {
 	if w.state != stateInit {
 		return fmt.Errorf("WriteStatusLine called out of order")
 	}
 
-	statusText := map[StatusCode]string{
-		StatusOK:                  "OK",
-		StatusBadRequest:          "Bad Request",
-		StatusInternalServerError: "Internal Server Error",
+	hooks := w.headerHooks
+	w.headerHooks = nil
+	for _, fn := range hooks {
+		fn(w)
 	}
 
-	text, ok := statusText[w.StatusCode]
-	if !ok {
-		text = "Unknown"
+	if !w.StatusCode.IsValid() || w.StatusCode.IsInformational() {
+		return fmt.Errorf("%w %d", ErrInvalidStatusCode, w.StatusCode)
 	}
 
-	if _, err := fmt.Fprintf(w.Out, "HTTP/1.1 %d %s"+CRLF, w.StatusCode, text); err != nil {
+	if err := w.checkBufferedLength(); err != nil {
+		return err
+	}
+
+	// Unregistered codes are sent with an empty reason phrase, which
+	// clients are required to accept.
+	text := StatusText(w.StatusCode)
+
+	if _, err := fmt.Fprintf(w.Out, "HTTP/%s %03d %s"+CRLF, w.version, w.StatusCode, text); err != nil {
 		return err
 	}
 
@@ -129,25 +473,80 @@ func (w *Writer) WriteStatusLine() error {
 	return nil
 }
 
+// checkBufferedLength refuses a body that is complete in the buffer but longer
+// than the Content-Length the handler set, before anything is sent: the excess
+// could not go out, and the client would be left with a truncated body.
+func (w *Writer) checkBufferedLength() error {
+	if !w.finishing || w.streaming || w.newEnc != nil || !w.sendsBody() || w.isChunked() {
+		return nil
+	}
+
+	v, ok := w.Headers.Get(ContLen)
+	if !ok {
+		return nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n >= int64(w.Body.Len()) {
+		return nil
+	}
+	return fmt.Errorf("%w: %d bytes buffered, %d declared", ErrContentLength, w.Body.Len(), n)
+}
+
 func (w *Writer) WriteHeaders() error {
 	if w.state != stateStatusWritten {
 		return fmt.Errorf("WriteHeaders called out of order")
 	}
 
-	if _, ok := w.Headers[ContLen]; !ok {
-		w.Headers[ContLen] = strconv.Itoa(len(w.Body.Bytes()))
+	if w.newEnc != nil {
+		if err := w.startEncoder(); err != nil {
+			return err
+		}
 	}
 
-	var headerStr strings.Builder
-	for k, v := range w.Headers {
-		headerStr.WriteString(k)
-		headerStr.WriteString(": ")
-		headerStr.WriteString(v)
-		headerStr.WriteString("\r\n")
+	hasLen := w.Headers.Has(ContLen)
+
+	switch {
+	case w.StatusCode.IsInformational() || w.StatusCode == StatusNoContent:
+		w.Headers.Del(ContLen)
+		w.Headers.Del(TransfEnc)
+	case w.StatusCode == StatusNotModified:
+		// A 304 may repeat the Content-Length of the cached
+		// representation, but it never has a body of its own.
+		w.Headers.Del(TransfEnc)
+	case w.version == "1.0" && (w.wantsChunked() || w.streaming):
+		w.Chunked = false
+		w.Headers.Del(TransfEnc)
+		if !hasLen {
+			// Without chunked encoding the only way to end the
+			// body is to close the connection.
+			w.keepAlive = false
+		}
+	case w.isChunked() || (w.streaming && !hasLen):
+		w.Chunked = true
+		w.Headers.Set(TransfEnc, "chunked")
+		w.Headers.Del(ContLen)
+	case !hasLen:
+		w.Headers.Set(ContLen, strconv.Itoa(w.Body.Len()))
 	}
-	headerStr.WriteString("\r\n")
 
-	if _, err := io.WriteString(w.Out, headerStr.String()); err != nil {
+	w.contentLength = -1
+	if w.sendsBody() && !w.isChunked() {
+		if v, ok := w.Headers.Get(ContLen); ok {
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				w.contentLength = n
+			}
+		}
+	}
+
+	if !w.Headers.Has(Conn) {
+		if w.keepAlive {
+			w.Headers.Set(Conn, "keep-alive")
+		} else {
+			w.Headers.Set(Conn, "close")
+		}
+	}
+
+	if _, err := io.WriteString(w.Out, fieldBlock(w.Headers)); err != nil {
 		return err
 	}
 
@@ -155,62 +554,79 @@ func (w *Writer) WriteHeaders() error {
 	return nil
 }
 
+// WriteBody sends the buffered body and ends it. For a chunked response that
+// means writing the last chunk too; trailers can still follow.
 func (w *Writer) WriteBody() (int, error) {
 	if w.state != stateHeadersWritten {
 		return 0, fmt.Errorf("WriteBody called out of order")
 	}
 
-	n, err := w.Out.Write(w.Body.Bytes())
+	n, err := w.writeBody(w.Body.Bytes())
 	if err != nil {
 		return 0, err
 	}
+	w.Body.Reset()
+
+	if err := w.closeEncoder(); err != nil {
+		return n, err
+	}
+
+	if w.isChunked() {
+		if _, err := w.WriteChunkedBodyDone(); err != nil {
+			return n, err
+		}
+	}
 
 	w.state = stateBodyWritten
 	return n, nil
 }
 
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
-	if len(p) == 0 {
-		return 0, nil
-	}
+	return w.writeBody(p)
+}
 
-	sizeLine := strconv.FormatInt(int64(len(p)), 16) + CRLF
-	if n, err := io.WriteString(w.Out, sizeLine); err != nil {
-		return n, err
+func (w *Writer) WriteChunkedBodyDone() (int, error) {
+	if err := w.closeEncoder(); err != nil {
+		return 0, err
 	}
 
-	n, err := w.Out.Write(p)
-	if err != nil {
+	if !w.sendsBody() || !w.isChunked() {
+		w.state = stateBodyWritten
 		return 0, nil
 	}
 
-	if _, err := io.WriteString(w.Out, CRLF); err != nil {
-		return n, err
-	}
-
-	return n, nil
-}
-
-func (w *Writer) WriteChunkedBodyDone() (int, error) {
 	n, err := io.WriteString(w.Out, "0"+CRLF)
 	w.state = stateBodyWritten
+	w.trailersPending = true
 	return n, err
 }
 
-func (w *Writer) WriteTrailers(h headers.Headers) error {
+func (w *Writer) WriteTrailers(h *headers.Headers) error {
 	if w.state != stateBodyWritten {
 		return fmt.Errorf("WriteTrailers called out of order")
 	}
 
-	var b strings.Builder
-	for k, v := range h {
-		b.WriteString(k)
-		b.WriteString(": ")
-		b.WriteString(v)
-		b.WriteString(CRLF)
+	if !w.sendsBody() || !w.isChunked() {
+		return nil
 	}
-	b.WriteString(CRLF)
 
-	_, err := io.WriteString(w.Out, b.String())
+	_, err := io.WriteString(w.Out, fieldBlock(h))
+	w.trailersPending = false
 	return err
 }
+
+// fieldBlock formats h as header (or trailer) lines followed by the blank
+// line that ends the section. h may be nil.
+func fieldBlock(h *headers.Headers) string {
+	var b strings.Builder
+	if h != nil {
+		for k, v := range h.All() {
+			b.WriteString(k)
+			b.WriteString(": ")
+			b.WriteString(v)
+			b.WriteString(CRLF)
+		}
+	}
+	b.WriteString(CRLF)
+	return b.String()
+}
@@ -0,0 +1,402 @@
+package response
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bufConn records everything written to it.
+type bufConn struct {
+	net.Conn
+	out bytes.Buffer
+}
+
+func (c *bufConn) Write(p []byte) (int, error) {
+	return c.out.Write(p)
+}
+
+func TestStatusText(t *testing.T) {
+	assert.Equal(t, "Not Found", StatusText(StatusNotFound))
+	assert.Equal(t, "Early Hints", StatusText(StatusEarlyHints))
+	assert.Equal(t, "Network Authentication Required", StatusText(StatusNetworkAuthenticationRequired))
+	assert.Equal(t, "", StatusText(299))
+}
+
+func TestWriteStatusLine(t *testing.T) {
+	// Test: Registered code
+	conn := &bufConn{}
+	w := NewWriter(conn)
+	w.StatusCode = StatusNotFound
+	require.NoError(t, w.WriteStatusLine())
+	assert.Equal(t, "HTTP/1.1 404 Not Found\r\n", conn.out.String())
+
+	// Test: Unregistered code keeps an empty reason phrase
+	conn = &bufConn{}
+	w = NewWriter(conn)
+	w.StatusCode = 299
+	require.NoError(t, w.WriteStatusLine())
+	assert.Equal(t, "HTTP/1.1 299 \r\n", conn.out.String())
+
+	// Test: Codes outside 100-999 and interim codes are refused
+	for _, code := range []StatusCode{0, 99, 1000, -200, StatusContinue, StatusEarlyHints, 199} {
+		conn = &bufConn{}
+		w = NewWriter(conn)
+		w.StatusCode = code
+		require.ErrorIs(t, w.WriteStatusLine(), ErrInvalidStatusCode)
+		assert.Equal(t, 0, conn.out.Len())
+		assert.False(t, w.Written())
+	}
+}
+
+func TestNoBodyStatuses(t *testing.T) {
+	for _, code := range []StatusCode{StatusNoContent, StatusNotModified} {
+		conn := &bufConn{}
+		w := NewWriter(conn)
+		w.StatusCode = code
+		w.WriteString("should not be sent")
+		require.NoError(t, w.WriteResponse())
+
+		out := conn.out.String()
+		assert.NotContains(t, out, "should not be sent")
+		assert.NotContains(t, out, "Transfer-Encoding")
+		assert.True(t, bytes.HasSuffix(conn.out.Bytes(), []byte("\r\n\r\n")), out)
+	}
+
+	// Test: 204 has no Content-Length even if the handler set one
+	conn := &bufConn{}
+	w := NewWriter(conn)
+	w.StatusCode = StatusNoContent
+	w.Headers.Set(ContLen, "10")
+	require.NoError(t, w.WriteResponse())
+	assert.NotContains(t, conn.out.String(), ContLen)
+
+	// Test: Chunked 204 writes no chunk framing
+	conn = &bufConn{}
+	w = NewWriter(conn)
+	w.StatusCode = StatusNoContent
+	w.Chunked = true
+	require.NoError(t, w.WriteStatusLine())
+	require.NoError(t, w.WriteHeaders())
+	w.WriteChunkedBody([]byte("data"))
+	w.WriteChunkedBodyDone()
+	w.Finish()
+	assert.True(t, bytes.HasSuffix(conn.out.Bytes(), []byte("\r\n\r\n")), conn.out.String())
+	assert.NotContains(t, conn.out.String(), "data")
+}
+
+func TestStreamingWrites(t *testing.T) {
+	// Test: Small body is buffered and gets a Content-Length
+	conn := &bufConn{}
+	w := NewWriter(conn)
+	w.WriteString("hello")
+	assert.Equal(t, 0, conn.out.Len())
+	require.NoError(t, w.WriteResponse())
+	assert.Contains(t, conn.out.String(), "Content-Length: 5\r\n")
+	assert.True(t, bytes.HasSuffix(conn.out.Bytes(), []byte("\r\n\r\nhello")))
+
+	// Test: Large body switches to chunked encoding
+	conn = &bufConn{}
+	w = NewWriter(conn)
+	big := bytes.Repeat([]byte("x"), maxBufferedBody+1)
+	n, err := w.Write(big)
+	require.NoError(t, err)
+	assert.Equal(t, len(big), n)
+	assert.True(t, w.Written())
+	w.WriteString("tail")
+	w.Finish()
+	out := conn.out.String()
+	assert.Contains(t, out, "Transfer-Encoding: chunked\r\n")
+	assert.NotContains(t, out, ContLen)
+	assert.True(t, bytes.HasSuffix(conn.out.Bytes(), []byte("4\r\ntail\r\n0\r\n\r\n")), out)
+
+	// Test: Handler-set Content-Length streams the body as is
+	conn = &bufConn{}
+	w = NewWriter(conn)
+	w.Headers.Set(ContLen, "5000")
+	w.Write(bytes.Repeat([]byte("y"), 5000))
+	w.Finish()
+	out = conn.out.String()
+	assert.Contains(t, out, "Content-Length: 5000\r\n")
+	assert.NotContains(t, out, TransfEnc)
+	assert.True(t, bytes.HasSuffix(conn.out.Bytes(), []byte("\r\n\r\n"+string(bytes.Repeat([]byte("y"), 5000)))))
+
+	// Test: Large body after an explicit status line is streamed too
+	conn = &bufConn{}
+	w = NewWriter(conn)
+	require.NoError(t, w.WriteStatusLine())
+	n, err = w.Write(bytes.Repeat([]byte("z"), 1<<20))
+	require.NoError(t, err)
+	assert.Equal(t, 1<<20, n)
+	assert.Equal(t, 0, w.Body.Len())
+	assert.Greater(t, conn.out.Len(), 1<<20)
+	assert.Contains(t, conn.out.String(), "Transfer-Encoding: chunked\r\n")
+	w.Finish()
+	assert.True(t, bytes.HasSuffix(conn.out.Bytes(), []byte("0\r\n\r\n")))
+
+	// Test: Explicit Flush sends the headers right away
+	conn = &bufConn{}
+	w = NewWriter(conn)
+	w.WriteString("part one")
+	require.NoError(t, w.Flush())
+	assert.Contains(t, conn.out.String(), "Transfer-Encoding: chunked\r\n")
+	assert.True(t, bytes.HasSuffix(conn.out.Bytes(), []byte("8\r\npart one\r\n")))
+	w.WriteString("two")
+	w.Finish()
+	assert.True(t, bytes.HasSuffix(conn.out.Bytes(), []byte("3\r\ntwo\r\n0\r\n\r\n")))
+}
+
+func TestContentLengthMismatch(t *testing.T) {
+	// Test: Body shorter than the declared length can't be reused
+	conn := &bufConn{}
+	w := NewWriter(conn)
+	w.SetKeepAlive(true)
+	w.Headers.Set(ContLen, "10")
+	w.WriteString("abc")
+	assert.False(t, w.Finish())
+	assert.True(t, bytes.HasSuffix(conn.out.Bytes(), []byte("\r\n\r\nabc")))
+
+	// Test: Write past the declared length is rejected
+	conn = &bufConn{}
+	w = NewWriter(conn)
+	w.SetKeepAlive(true)
+	w.Headers.Set(ContLen, "3")
+	require.NoError(t, w.Flush())
+	_, err := w.WriteString("abc")
+	require.NoError(t, err)
+	n, err := w.WriteString("d")
+	require.ErrorIs(t, err, ErrContentLength)
+	assert.Equal(t, 0, n)
+	assert.True(t, w.Finish())
+	assert.True(t, bytes.HasSuffix(conn.out.Bytes(), []byte("\r\n\r\nabc")))
+
+	// Test: Buffered body longer than the declared length
+	conn = &bufConn{}
+	w = NewWriter(conn)
+	w.SetKeepAlive(true)
+	w.Headers.Set(ContLen, "2")
+	w.WriteString("abc")
+	require.ErrorIs(t, w.WriteResponse(), ErrContentLength)
+	assert.False(t, w.Written())
+	assert.Empty(t, conn.out.String())
+
+	// Test: HEAD sends no body but the connection stays usable
+	conn = &bufConn{}
+	w = NewWriter(conn)
+	w.SetKeepAlive(true)
+	w.SetRequestMethod("HEAD")
+	w.Headers.Set(ContLen, "10")
+	assert.True(t, w.Finish())
+}
+
+func TestHeaderOrder(t *testing.T) {
+	conn := &bufConn{}
+	w := NewWriter(conn)
+	w.Headers.Add("Set-Cookie", "a=1")
+	w.Headers.Add("X-Trace", "abc")
+	w.Headers.Add("Set-Cookie", "b=2")
+	w.WriteString("ok")
+	require.NoError(t, w.WriteResponse())
+
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Type: text/html\r\n"+
+		"Set-Cookie: a=1\r\n"+
+		"X-Trace: abc\r\n"+
+		"Set-Cookie: b=2\r\n"+
+		"Content-Length: 2\r\n"+
+		"Connection: close\r\n"+
+		"\r\n"+
+		"ok", conn.out.String())
+}
+
+func TestHeaderKeysAreCanonical(t *testing.T) {
+	conn := &bufConn{}
+	w := NewWriter(conn)
+	w.Headers.Set("content-type", "text/plain")
+	w.Headers.Set("content-length", "2")
+	w.Headers.Set("x-request-id", "7")
+	w.WriteString("ok")
+	require.NoError(t, w.WriteResponse())
+
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"Content-Length: 2\r\n"+
+		"X-Request-Id: 7\r\n"+
+		"Connection: close\r\n"+
+		"\r\n"+
+		"ok", conn.out.String())
+}
+
+func TestHeadResponse(t *testing.T) {
+	// Test: Buffered body keeps its real Content-Length but is not sent
+	conn := &bufConn{}
+	w := NewWriter(conn)
+	w.SetRequestMethod("HEAD")
+	w.WriteString("hello")
+	w.Finish()
+	out := conn.out.String()
+	assert.Contains(t, out, "Content-Length: 5\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"), out)
+
+	// Test: Streamed body announces chunked encoding but sends no chunks
+	conn = &bufConn{}
+	w = NewWriter(conn)
+	w.SetRequestMethod("HEAD")
+	w.Write(bytes.Repeat([]byte("x"), maxBufferedBody+1))
+	w.WriteString("tail")
+	w.Finish()
+	out = conn.out.String()
+	assert.Contains(t, out, "Transfer-Encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"), out)
+	assert.NotContains(t, out, "tail")
+
+	// Test: Handler-set Content-Length is kept
+	conn = &bufConn{}
+	w = NewWriter(conn)
+	w.SetRequestMethod("HEAD")
+	w.Headers.Set(ContLen, "5000")
+	w.Write(bytes.Repeat([]byte("y"), 5000))
+	w.Finish()
+	out = conn.out.String()
+	assert.Contains(t, out, "Content-Length: 5000\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"), out)
+}
+
+func TestHTTP10Response(t *testing.T) {
+	// Test: Status line carries the negotiated version
+	conn := &bufConn{}
+	w := NewWriter(conn)
+	w.SetHTTPVersion("1.0")
+	w.SetKeepAlive(true)
+	w.WriteString("hello")
+	assert.True(t, w.Finish())
+	out := conn.out.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.0 200 OK\r\n"), out)
+	assert.Contains(t, out, "Content-Length: 5\r\n")
+	assert.Contains(t, out, "Connection: keep-alive\r\n")
+
+	// Test: Streamed body is not chunked and closes the connection
+	conn = &bufConn{}
+	w = NewWriter(conn)
+	w.SetHTTPVersion("1.0")
+	w.SetKeepAlive(true)
+	w.WriteString("part one")
+	require.NoError(t, w.Flush())
+	w.WriteString(", part two")
+	assert.False(t, w.Finish())
+	out = conn.out.String()
+	assert.NotContains(t, out, TransfEnc)
+	assert.NotContains(t, out, ContLen)
+	assert.Contains(t, out, "Connection: close\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\npart one, part two"), out)
+}
+
+func TestWriteInformational(t *testing.T) {
+	// Test: Interim response goes out ahead of the final one
+	conn := &bufConn{}
+	w := NewWriter(conn)
+	require.NoError(t, w.WriteInformational(StatusContinue, nil))
+	assert.False(t, w.Written())
+	w.WriteString("ok")
+	require.NoError(t, w.WriteResponse())
+	assert.True(t, strings.HasPrefix(conn.out.String(),
+		"HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 200 OK\r\n"), conn.out.String())
+
+	// Test: Only 1xx codes other than 101
+	assert.Error(t, w.WriteInformational(StatusOK, nil))
+	w = NewWriter(&bufConn{})
+	assert.Error(t, w.WriteInformational(StatusOK, nil))
+	assert.Error(t, w.WriteInformational(StatusSwitchingProtocols, nil))
+
+	// Test: Not after the final status line
+	w = NewWriter(&bufConn{})
+	require.NoError(t, w.WriteStatusLine())
+	assert.Error(t, w.WriteInformational(StatusContinue, nil))
+
+	// Test: Nothing is sent to HTTP/1.0 clients
+	conn = &bufConn{}
+	w = NewWriter(conn)
+	w.SetHTTPVersion("1.0")
+	require.NoError(t, w.WriteInformational(StatusContinue, nil))
+	assert.Equal(t, 0, conn.out.Len())
+}
+
+func TestEarlyHints(t *testing.T) {
+	conn := &bufConn{}
+	w := NewWriter(conn)
+	require.NoError(t, w.WriteEarlyHints(
+		"</style.css>; rel=preload; as=style",
+		"</font.woff2>; rel=preload; as=font; crossorigin"))
+	require.NoError(t, w.WriteEarlyHints("</app.js>; rel=preload; as=script"))
+	w.WriteString("ok")
+	require.NoError(t, w.WriteResponse())
+
+	out := conn.out.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 103 Early Hints\r\n"+
+		"Link: </style.css>; rel=preload; as=style\r\n"+
+		"Link: </font.woff2>; rel=preload; as=font; crossorigin\r\n"+
+		"\r\n"+
+		"HTTP/1.1 103 Early Hints\r\n"+
+		"Link: </app.js>; rel=preload; as=script\r\n"+
+		"\r\n"+
+		"HTTP/1.1 200 OK\r\n"), out)
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nok"), out)
+	assert.Equal(t, 1, strings.Count(out, "HTTP/1.1 200 OK"))
+}
+
+// upperEncoder stands in for a real compressor: it upper-cases the body and
+// marks where Close was called.
+type upperEncoder struct {
+	dst io.Writer
+}
+
+func (e upperEncoder) Write(p []byte) (int, error) {
+	return e.dst.Write(bytes.ToUpper(p))
+}
+
+func (e upperEncoder) Close() error {
+	_, err := io.WriteString(e.dst, "!")
+	return err
+}
+
+func TestSetEncoder(t *testing.T) {
+	newEnc := func(dst io.Writer) io.WriteCloser { return upperEncoder{dst} }
+
+	// Test: Complete body is encoded in memory and keeps a Content-Length
+	conn := &bufConn{}
+	w := NewWriter(conn)
+	w.Headers.Set(ContLen, "5")
+	w.OnWriteHeader(func(w *Writer) {
+		n, ok := w.ContentLength()
+		assert.True(t, ok)
+		assert.Equal(t, int64(5), n)
+		w.SetEncoder(newEnc)
+	})
+	w.WriteString("hello")
+	require.NoError(t, w.WriteResponse())
+	out := conn.out.String()
+	assert.Contains(t, out, "Content-Length: 6\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nHELLO!"), out)
+
+	// Test: Streamed body is encoded as it is written and sent chunked
+	conn = &bufConn{}
+	w = NewWriter(conn)
+	w.OnWriteHeader(func(w *Writer) {
+		_, ok := w.ContentLength()
+		assert.False(t, ok)
+		w.SetEncoder(newEnc)
+	})
+	w.WriteString("part one")
+	require.NoError(t, w.Flush())
+	w.WriteString(", part two")
+	w.Finish()
+	out = conn.out.String()
+	assert.Contains(t, out, "Transfer-Encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(out,
+		"8\r\nPART ONE\r\na\r\n, PART TWO\r\n1\r\n!\r\n0\r\n\r\n"), out)
+}
@@ -0,0 +1,309 @@
+package router
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/tsironi93/miniHttp/internal/request"
+	"github.com/tsironi93/miniHttp/internal/response"
+	"github.com/tsironi93/miniHttp/internal/server"
+)
+
+type segmentKind int
+
+// Kinds are ordered by precedence: when several routes match a path, the one
+// with the more specific segment wins at the first position they differ.
+const (
+	literal segmentKind = iota
+	param
+	wildcard
+)
+
+type segment struct {
+	kind  segmentKind
+	value string
+}
+
+type route struct {
+	method   string
+	pattern  string
+	segments []segment
+	handler  server.HandlerFunc
+	group    *Group
+}
+
+// Router dispatches requests by method and path pattern. Patterns are made of
+// '/'-separated segments, each either a literal, a parameter such as {id}
+// matching exactly one segment, or, as the last segment only, a wildcard such
+// as {rest...} matching the remainder of the path.
+type Router struct {
+	routes []*route
+	root   Group
+}
+
+// Group registers routes under a common path prefix and wraps them with its
+// own middleware, in addition to that of the groups it is nested in.
+type Group struct {
+	rt          *Router
+	parent      *Group
+	prefix      string
+	middlewares []server.Middleware
+}
+
+func New() *Router {
+	rt := &Router{}
+	rt.root.rt = rt
+	return rt
+}
+
+// Use adds middleware around every request the router serves, including the
+// ones it answers with 404 or 405.
+func (rt *Router) Use(mws ...server.Middleware) {
+	rt.root.Use(mws...)
+}
+
+func (rt *Router) Group(prefix string, mws ...server.Middleware) *Group {
+	return rt.root.Group(prefix, mws...)
+}
+
+func (rt *Router) Handle(method, pattern string, h server.HandlerFunc) {
+	rt.root.Handle(method, pattern, h)
+}
+
+func (rt *Router) Get(pattern string, h server.HandlerFunc) {
+	rt.root.Handle(request.MethodGet, pattern, h)
+}
+
+func (rt *Router) Post(pattern string, h server.HandlerFunc) {
+	rt.root.Handle(request.MethodPost, pattern, h)
+}
+
+func (rt *Router) Put(pattern string, h server.HandlerFunc) {
+	rt.root.Handle(request.MethodPut, pattern, h)
+}
+
+func (rt *Router) Patch(pattern string, h server.HandlerFunc) {
+	rt.root.Handle(request.MethodPatch, pattern, h)
+}
+
+func (rt *Router) Delete(pattern string, h server.HandlerFunc) {
+	rt.root.Handle(request.MethodDelete, pattern, h)
+}
+
+// Use adds middleware to the routes of g and of its nested groups, whether
+// they were registered before or after the call.
+func (g *Group) Use(mws ...server.Middleware) {
+	g.middlewares = append(g.middlewares, mws...)
+}
+
+// Group returns a group nested in g whose routes are prefixed by prefix.
+func (g *Group) Group(prefix string, mws ...server.Middleware) *Group {
+	return &Group{
+		rt:          g.rt,
+		parent:      g,
+		prefix:      g.prefix + strings.TrimSuffix(prefix, "/"),
+		middlewares: mws,
+	}
+}
+
+// Handle registers h for requests with the given method whose path matches
+// the group prefix followed by pattern. It panics on malformed patterns.
+func (g *Group) Handle(method, pattern string, h server.HandlerFunc) {
+	pattern = g.prefix + pattern
+	g.rt.routes = append(g.rt.routes, &route{
+		method:   method,
+		pattern:  pattern,
+		segments: parsePattern(pattern),
+		handler:  h,
+		group:    g,
+	})
+}
+
+func (g *Group) Get(pattern string, h server.HandlerFunc) {
+	g.Handle(request.MethodGet, pattern, h)
+}
+
+func (g *Group) Post(pattern string, h server.HandlerFunc) {
+	g.Handle(request.MethodPost, pattern, h)
+}
+
+func (g *Group) Put(pattern string, h server.HandlerFunc) {
+	g.Handle(request.MethodPut, pattern, h)
+}
+
+func (g *Group) Patch(pattern string, h server.HandlerFunc) {
+	g.Handle(request.MethodPatch, pattern, h)
+}
+
+func (g *Group) Delete(pattern string, h server.HandlerFunc) {
+	g.Handle(request.MethodDelete, pattern, h)
+}
+
+// chain returns the middleware of g and its parents, outermost first. The
+// root group is left out because Serve applies it to the whole router.
+func (g *Group) chain() []server.Middleware {
+	if g.parent == nil {
+		return nil
+	}
+	return append(g.parent.chain(), g.middlewares...)
+}
+
+func parsePattern(pattern string) []segment {
+	if !strings.HasPrefix(pattern, "/") {
+		panic("router: pattern must start with '/': " + pattern)
+	}
+
+	parts := strings.Split(pattern[1:], "/")
+	segments := make([]segment, 0, len(parts))
+
+	for i, part := range parts {
+		if !strings.HasPrefix(part, "{") || !strings.HasSuffix(part, "}") {
+			segments = append(segments, segment{kind: literal, value: part})
+			continue
+		}
+
+		name := part[1 : len(part)-1]
+		if rest, ok := strings.CutSuffix(name, "..."); ok {
+			if i != len(parts)-1 {
+				panic("router: wildcard must be the last segment: " + pattern)
+			}
+			segments = append(segments, segment{kind: wildcard, value: rest})
+			continue
+		}
+
+		if name == "" {
+			panic("router: empty parameter name: " + pattern)
+		}
+		segments = append(segments, segment{kind: param, value: name})
+	}
+
+	return segments
+}
+
+// match reports whether path fits the route and returns the captured
+// parameters.
+func (r *route) match(parts []string) (map[string]string, bool) {
+	params := make(map[string]string)
+
+	for i, seg := range r.segments {
+		if seg.kind == wildcard {
+			params[seg.value] = strings.Join(parts[i:], "/")
+			return params, true
+		}
+
+		if i >= len(parts) {
+			return nil, false
+		}
+
+		switch seg.kind {
+		case literal:
+			if parts[i] != seg.value {
+				return nil, false
+			}
+		case param:
+			params[seg.value] = parts[i]
+		}
+	}
+
+	return params, len(parts) == len(r.segments)
+}
+
+// moreSpecific reports whether r should win over other when both match.
+func (r *route) moreSpecific(other *route) bool {
+	for i := 0; i < len(r.segments) && i < len(other.segments); i++ {
+		if r.segments[i].kind != other.segments[i].kind {
+			return r.segments[i].kind < other.segments[i].kind
+		}
+	}
+	return len(r.segments) > len(other.segments)
+}
+
+type match struct {
+	route  *route
+	params map[string]string
+}
+
+// pick returns the most specific match registered for method. Among equally
+// specific routes the first one registered wins.
+func pick(matches []match, method string) *match {
+	var best *match
+	for i := range matches {
+		m := &matches[i]
+		if m.route.method != method {
+			continue
+		}
+		if best == nil || m.route.moreSpecific(best.route) {
+			best = m
+		}
+	}
+	return best
+}
+
+// Serve is a server.HandlerFunc that runs the best matching route. It answers
+// 404 Not Found when no pattern matches the path, and 405 Method Not Allowed,
+// with an Allow header, when patterns match but not for this method. HEAD
+// requests fall back to GET routes, and OPTIONS requests without a route of
+// their own get 204 No Content listing the allowed methods.
+func (rt *Router) Serve(w *response.Writer, req *request.Request) {
+	server.Chain(rt.dispatch, rt.root.middlewares...)(w, req)
+}
+
+func (rt *Router) dispatch(w *response.Writer, req *request.Request) {
+	// Split the escaped path so that a "%2F" stays inside its segment.
+	parts := strings.Split(strings.TrimPrefix(req.RequestLine.RawPath, "/"), "/")
+	for i, part := range parts {
+		parts[i], _ = url.PathUnescape(part)
+	}
+	method := req.RequestLine.Method
+
+	var matches []match
+	for _, r := range rt.routes {
+		if params, ok := r.match(parts); ok {
+			matches = append(matches, match{route: r, params: params})
+		}
+	}
+
+	best := pick(matches, method)
+	if best == nil && method == request.MethodHead {
+		best = pick(matches, request.MethodGet)
+	}
+
+	if best != nil {
+		req.Params = best.params
+		server.Chain(best.route.handler, best.route.group.chain()...)(w, req)
+		return
+	}
+
+	if len(matches) == 0 {
+		w.SetError(response.StatusNotFound)
+		return
+	}
+
+	allow := strings.Join(allowedMethods(matches), ", ")
+	if method == request.MethodOptions {
+		w.StatusCode = response.StatusNoContent
+		w.Headers.Set("Allow", allow)
+		return
+	}
+
+	w.SetError(response.StatusMethodNotAllowed)
+	w.Headers.Set("Allow", allow)
+}
+
+func allowedMethods(matches []match) []string {
+	seen := map[string]bool{request.MethodOptions: true}
+	for _, m := range matches {
+		seen[m.route.method] = true
+		if m.route.method == request.MethodGet {
+			seen[request.MethodHead] = true
+		}
+	}
+
+	methods := make([]string, 0, len(seen))
+	for m := range seen {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	return methods
+}
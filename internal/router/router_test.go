@@ -0,0 +1,182 @@
+package router
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tsironi93/miniHttp/internal/request"
+	"github.com/tsironi93/miniHttp/internal/response"
+	"github.com/tsironi93/miniHttp/internal/server"
+)
+
+func newRequest(t *testing.T, method, target string) *request.Request {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(
+		method + " " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	return req
+}
+
+// serve runs the router and returns the name of the route that handled the
+// request, or "" if none did.
+func serve(t *testing.T, rt *Router, method, target string) (string, *request.Request, *response.Writer) {
+	t.Helper()
+	req := newRequest(t, method, target)
+	w := response.NewWriter(nil)
+
+	rt.Serve(w, req)
+	return header(w, "X-Route"), req, w
+}
+
+func header(w *response.Writer, key string) string {
+	v, _ := w.Headers.Get(key)
+	return v
+}
+
+func named(name string) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		w.Headers.Set("X-Route", name)
+	}
+}
+
+func TestRouter(t *testing.T) {
+	rt := New()
+	rt.Get("/users", named("list"))
+	rt.Post("/users", named("create"))
+	rt.Get("/users/{id}", named("show"))
+	rt.Get("/users/me", named("me"))
+	rt.Get("/users/{id}/posts/{post}", named("post"))
+	rt.Get("/static/{file...}", named("static"))
+	rt.Delete("/users/{id}", named("delete"))
+	rt.Put("/users/{id}", named("update"))
+	rt.Get("/admin", named("admin"))
+
+	// Test: Literal route
+	name, _, _ := serve(t, rt, "GET", "/users")
+	assert.Equal(t, "list", name)
+
+	// Test: Method selects the route
+	name, _, _ = serve(t, rt, "POST", "/users")
+	assert.Equal(t, "create", name)
+
+	// Test: Path parameter
+	name, req, _ := serve(t, rt, "GET", "/users/42")
+	assert.Equal(t, "show", name)
+	assert.Equal(t, "42", req.Param("id"))
+
+	// Test: Literal segment beats a parameter
+	name, _, _ = serve(t, rt, "GET", "/users/me")
+	assert.Equal(t, "me", name)
+
+	// Test: Several parameters
+	name, req, _ = serve(t, rt, "GET", "/users/7/posts/99")
+	assert.Equal(t, "post", name)
+	assert.Equal(t, "7", req.Param("id"))
+	assert.Equal(t, "99", req.Param("post"))
+
+	// Test: Wildcard captures the rest of the path
+	name, req, _ = serve(t, rt, "GET", "/static/css/site.css")
+	assert.Equal(t, "static", name)
+	assert.Equal(t, "css/site.css", req.Param("file"))
+
+	// Test: Query string is ignored for matching
+	name, _, _ = serve(t, rt, "GET", "/users?page=2")
+	assert.Equal(t, "list", name)
+
+	// Test: Path is matched after decoding and dot-segment removal
+	name, req, _ = serve(t, rt, "GET", "/static/img/../css/a%20b.css")
+	assert.Equal(t, "static", name)
+	assert.Equal(t, "css/a b.css", req.Param("file"))
+
+	// Test: Escaped slash stays inside its segment
+	name, req, _ = serve(t, rt, "GET", "/users/a%2Fb")
+	assert.Equal(t, "show", name)
+	assert.Equal(t, "a/b", req.Param("id"))
+
+	// Test: Escaped slashes can't form a ".." that climbs out of a segment
+	name, _, w := serve(t, rt, "GET", "/public%2F..%2Fadmin")
+	assert.Equal(t, "", name)
+	assert.Equal(t, response.StatusNotFound, w.StatusCode)
+
+	// Test: HEAD falls back to GET
+	name, _, _ = serve(t, rt, "HEAD", "/users/42")
+	assert.Equal(t, "show", name)
+
+	// Test: Unknown path
+	name, _, w = serve(t, rt, "GET", "/nope")
+	assert.Equal(t, "", name)
+	assert.Equal(t, response.StatusNotFound, w.StatusCode)
+
+	// Test: Method other than GET and POST
+	name, _, _ = serve(t, rt, "PUT", "/users/42")
+	assert.Equal(t, "update", name)
+
+	// Test: Known path, wrong method
+	name, _, w = serve(t, rt, "POST", "/users/42")
+	assert.Equal(t, "", name)
+	assert.Equal(t, response.StatusMethodNotAllowed, w.StatusCode)
+	assert.Equal(t, "DELETE, GET, HEAD, OPTIONS, PUT", header(w, "Allow"))
+
+	// Test: OPTIONS without a route lists the allowed methods
+	name, _, w = serve(t, rt, "OPTIONS", "/users/42")
+	assert.Equal(t, "", name)
+	assert.Equal(t, response.StatusNoContent, w.StatusCode)
+	assert.Equal(t, "DELETE, GET, HEAD, OPTIONS, PUT", header(w, "Allow"))
+
+	// Test: OPTIONS on an unknown path
+	_, _, w = serve(t, rt, "OPTIONS", "/nope")
+	assert.Equal(t, response.StatusNotFound, w.StatusCode)
+}
+
+func TestInvalidPatterns(t *testing.T) {
+	rt := New()
+	assert.Panics(t, func() { rt.Get("users", named("x")) })
+	assert.Panics(t, func() { rt.Get("/{rest...}/more", named("x")) })
+	assert.Panics(t, func() { rt.Get("/users/{}", named("x")) })
+}
+
+func tag(value string) func(next server.HandlerFunc) server.HandlerFunc {
+	return func(next server.HandlerFunc) server.HandlerFunc {
+		return func(w *response.Writer, req *request.Request) {
+			w.Headers.Set("X-Tags", header(w, "X-Tags")+value)
+			next(w, req)
+		}
+	}
+}
+
+func TestGroups(t *testing.T) {
+	rt := New()
+	rt.Use(tag("r"))
+	rt.Get("/", named("home"))
+
+	api := rt.Group("/api", tag("a"))
+	api.Get("/users/{id}", named("user"))
+
+	admin := api.Group("/admin")
+	admin.Get("/stats", named("stats"))
+	admin.Use(tag("x"))
+
+	// Test: Router middleware only
+	name, _, w := serve(t, rt, "GET", "/")
+	assert.Equal(t, "home", name)
+	assert.Equal(t, "r", header(w, "X-Tags"))
+
+	// Test: Group prefix and middleware
+	name, req, w := serve(t, rt, "GET", "/api/users/5")
+	assert.Equal(t, "user", name)
+	assert.Equal(t, "5", req.Param("id"))
+	assert.Equal(t, "ra", header(w, "X-Tags"))
+
+	// Test: Nested group, middleware added after the route
+	name, _, w = serve(t, rt, "GET", "/api/admin/stats")
+	assert.Equal(t, "stats", name)
+	assert.Equal(t, "rax", header(w, "X-Tags"))
+
+	// Test: Router middleware also sees 404s
+	_, _, w = serve(t, rt, "GET", "/api/missing")
+	assert.Equal(t, response.StatusNotFound, w.StatusCode)
+	assert.Equal(t, "r", header(w, "X-Tags"))
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"log"
+	"net"
+	"time"
+
+	"github.com/tsironi93/miniHttp/internal/request"
+	"github.com/tsironi93/miniHttp/internal/response"
+)
+
+const (
+	defaultReadHeaderTimeout  = 10 * time.Second
+	defaultIdleTimeout        = 30 * time.Second
+	defaultMaxRequestsPerConn = 100
+)
+
+// DefaultMethods are the methods a server implements unless configured
+// otherwise. CONNECT and TRACE are left out: a handler written for ordinary
+// requests would answer them as if they were GETs, when one asks for a tunnel
+// and the other for the request to be echoed back.
+var DefaultMethods = []string{
+	request.MethodGet,
+	request.MethodHead,
+	request.MethodPost,
+	request.MethodPut,
+	request.MethodPatch,
+	request.MethodDelete,
+	request.MethodOptions,
+}
+
+// ErrorHandler writes the response for a request the server rejected before
+// it reached the handler. The writer already carries the status code.
+type ErrorHandler func(w *response.Writer, herr *HandleError)
+
+// PanicHandler is told about every panic recovered from a handler, e.g. to
+// forward it to an error tracker. stack is the trace of the panicking
+// goroutine. req is nil if the panic came from the ErrorHandler answering a
+// request that could not be parsed.
+type PanicHandler func(req *request.Request, v any, stack []byte)
+
+// Config describes how a Server listens and serves. Start from DefaultConfig;
+// a zero timeout or limit means none.
+type Config struct {
+	// Addr is the TCP address to listen on, e.g. ":42069" or
+	// "127.0.0.1:0" for an ephemeral port. It is ignored when Listener
+	// is set.
+	Addr     string
+	Listener net.Listener
+	Handler  HandlerFunc
+
+	// ReadHeaderTimeout bounds the time from the first byte of a request
+	// to the end of its headers; a client that is too slow gets 408
+	// Request Timeout. Zero falls back to ReadTimeout.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout bounds the time from the first byte of a request to the
+	// end of its body.
+	ReadTimeout time.Duration
+	// WriteTimeout bounds the time from the end of the request headers to
+	// the end of the response.
+	WriteTimeout time.Duration
+	// IdleTimeout bounds how long a keep-alive connection may sit between
+	// requests. Zero falls back to ReadTimeout.
+	IdleTimeout time.Duration
+
+	MaxRequestsPerConn int
+	// Limits caps request sizes. Requests over a limit are answered with
+	// 413, 414 or 431 instead of being handed to Handler.
+	Limits request.Limits
+	// Methods lists the request methods the server implements. Any other
+	// method is answered with 501 Not Implemented without reaching
+	// Handler. Nil means DefaultMethods.
+	Methods []string
+
+	Logger       *log.Logger
+	ErrorHandler ErrorHandler
+	PanicHandler PanicHandler
+}
+
+func DefaultConfig() Config {
+	return Config{
+		ReadHeaderTimeout:  defaultReadHeaderTimeout,
+		IdleTimeout:        defaultIdleTimeout,
+		MaxRequestsPerConn: defaultMaxRequestsPerConn,
+		Limits:             request.DefaultLimits,
+		Methods:            DefaultMethods,
+		Logger:             log.Default(),
+		ErrorHandler:       defaultErrorHandler,
+	}
+}
+
+func defaultErrorHandler(w *response.Writer, herr *HandleError) {
+	w.SetError(herr.StatusCode)
+}
+
+// Option adjusts the Config used by Serve.
+type Option func(*Config)
+
+func WithLimits(limits request.Limits) Option {
+	return func(c *Config) {
+		c.Limits = limits
+	}
+}
+
+func WithReadHeaderTimeout(d time.Duration) Option {
+	return func(c *Config) {
+		c.ReadHeaderTimeout = d
+	}
+}
+
+func WithReadTimeout(d time.Duration) Option {
+	return func(c *Config) {
+		c.ReadTimeout = d
+	}
+}
+
+func WithWriteTimeout(d time.Duration) Option {
+	return func(c *Config) {
+		c.WriteTimeout = d
+	}
+}
+
+func WithIdleTimeout(d time.Duration) Option {
+	return func(c *Config) {
+		c.IdleTimeout = d
+	}
+}
+
+func WithMaxRequestsPerConn(n int) Option {
+	return func(c *Config) {
+		c.MaxRequestsPerConn = n
+	}
+}
+
+// WithMethods replaces the set of implemented methods, e.g. to add CONNECT,
+// TRACE or an extension method.
+func WithMethods(methods ...string) Option {
+	return func(c *Config) {
+		c.Methods = methods
+	}
+}
+
+func WithLogger(logger *log.Logger) Option {
+	return func(c *Config) {
+		c.Logger = logger
+	}
+}
+
+func WithErrorHandler(h ErrorHandler) Option {
+	return func(c *Config) {
+		c.ErrorHandler = h
+	}
+}
+
+func WithPanicHandler(h PanicHandler) Option {
+	return func(c *Config) {
+		c.PanicHandler = h
+	}
+}
@@ -0,0 +1,15 @@
+package server
+
+// Middleware wraps a HandlerFunc with cross-cutting behavior such as logging,
+// auth or recovery. It may adjust the response writer before calling next, or
+// register a hook with Writer.OnWriteHeader to see the final status first.
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Chain wraps h with mws. The first middleware is the outermost one, so it
+// runs first on the way in and last on the way out.
+func Chain(h HandlerFunc, mws ...Middleware) HandlerFunc {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
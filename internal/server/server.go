@@ -1,71 +1,448 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/tsironi93/miniHttp/internal/request"
 	"github.com/tsironi93/miniHttp/internal/response"
 )
 
+const (
+	shutdownPollInterval = 50 * time.Millisecond
+
+	// Accept errors such as running out of file descriptors tend to
+	// persist for a while, so retries back off between these bounds.
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = 1 * time.Second
+)
+
+type connState int
+
+const (
+	stateIdle connState = iota
+	stateActive
+)
+
 type Server struct {
+	cfg      Config
 	listener net.Listener
-	handler  HandlerFunc
 	closed   atomic.Bool
+
+	mu    sync.Mutex
+	conns map[net.Conn]connState
 }
 
+// HandleError describes why the server rejected a request on its own.
 type HandleError struct {
 	StatusCode response.StatusCode
 	Msg        string
 }
 
+func (e *HandleError) Error() string {
+	return fmt.Sprintf("%d: %s", e.StatusCode, e.Msg)
+}
+
+// Addr returns the address the server is listening on, which is useful when
+// it was started on port 0.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops the server immediately, dropping every open connection along
+// with any response still being written.
 func (s *Server) Close() error {
 	s.closed.Store(true)
-	return s.listener.Close()
+	err := s.listener.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return err
+}
+
+// Shutdown stops accepting connections, closes the idle ones and waits for
+// in-flight requests to finish. Connections still open when ctx expires are
+// closed forcibly and ctx's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closed.Store(true)
+	err := s.listener.Close()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if s.closeIdleConns() {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeIdleConns closes connections that are between requests and reports
+// whether no connections are left.
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn, state := range s.conns {
+		if state == stateIdle {
+			conn.Close()
+			delete(s.conns, conn)
+		}
+	}
+	return len(s.conns) == 0
+}
+
+func (s *Server) setState(conn net.Conn, state connState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns[conn] = state
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
 }
 
 type HandlerFunc func(w *response.Writer, req *request.Request)
 
-func (s *Server) handle(conn net.Conn) {
-	defer conn.Close()
+// errorStatus picks the status code for a request that could not be parsed.
+func errorStatus(err error) response.StatusCode {
+	switch {
+	case errors.Is(err, request.ErrRequestLineTooLong):
+		return response.StatusURITooLong
+	case errors.Is(err, request.ErrHeaderTooLarge):
+		return response.StatusRequestHeaderFieldsTooLarge
+	case errors.Is(err, request.ErrBodyTooLarge):
+		return response.StatusContentTooLarge
+	case errors.Is(err, request.ErrVersionNotSupported):
+		return response.StatusHTTPVersionNotSupported
+	case errors.Is(err, request.ErrUnsupportedTransferCoding):
+		return response.StatusNotImplemented
+	case errors.Is(err, request.ErrExpectationFailed):
+		return response.StatusExpectationFailed
+	default:
+		return response.StatusBadRequest
+	}
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// deadline turns a timeout into a connection deadline; zero means none.
+func deadline(start time.Time, d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return start.Add(d)
+}
+
+func (s *Server) headerTimeout() time.Duration {
+	if s.cfg.ReadHeaderTimeout > 0 {
+		return s.cfg.ReadHeaderTimeout
+	}
+	return s.cfg.ReadTimeout
+}
 
-	req, err := request.RequestFromReader(conn)
-	if err != nil {
-		response.WriteBadRequestResponse(conn)
-		return
+func (s *Server) waitTimeout(served int) time.Duration {
+	if served == 1 {
+		return s.headerTimeout()
+	}
+	if s.cfg.IdleTimeout > 0 {
+		return s.cfg.IdleTimeout
 	}
+	return s.cfg.ReadTimeout
+}
+
+func (s *Server) writeError(conn net.Conn, code response.StatusCode, err error) {
+	conn.SetWriteDeadline(deadline(time.Now(), s.cfg.WriteTimeout))
 
 	rw := response.NewWriter(conn)
+	defer func() {
+		if v := recover(); v != nil {
+			s.recovered(rw, nil, v)
+		}
+	}()
+
+	rw.StatusCode = code
+	s.cfg.ErrorHandler(rw, &HandleError{StatusCode: code, Msg: err.Error()})
+	s.finish(rw, nil)
+}
+
+// finish completes the response to req, which is nil when the request could
+// not be parsed, and reports whether the connection can be reused. A status
+// code that can't go on the status line, or a buffered body longer than the
+// Content-Length the handler set, is a bug in the handler: it is logged and
+// the client gets 500 Internal Server Error rather than a broken reply.
+func (s *Server) finish(w *response.Writer, req *request.Request) bool {
+	if err := w.WriteResponse(); err != nil {
+		if w.Written() || !(errors.Is(err, response.ErrInvalidStatusCode) ||
+			errors.Is(err, response.ErrContentLength)) {
+			return false
+		}
+
+		if req != nil {
+			s.cfg.Logger.Printf("%s %s: %v; sending 500 instead",
+				req.RequestLine.Method, req.RequestLine.RequestTarget, err)
+		} else {
+			s.cfg.Logger.Printf("error response: %v; sending 500 instead", err)
+		}
+
+		w.SetKeepAlive(false)
+		w.Headers = response.GetDefaultHeaders()
+		w.SetEncoder(nil)
+		w.SetError(response.StatusInternalServerError)
+	}
+	return w.Finish()
+}
 
-	s.handler(rw, req)
+// serveOptions answers "OPTIONS *", which asks about the server rather than
+// a resource, by listing the implemented methods.
+func (s *Server) serveOptions(w *response.Writer) {
+	w.StatusCode = response.StatusOK
+	w.Headers.Set("Allow", strings.Join(s.cfg.Methods, ", "))
+	w.Headers.Del(response.ContType)
+}
+
+// recovered handles a panic v raised by handler-supplied code while w was
+// being written: the handler, an OnWriteHeader hook or encoder run by Finish,
+// or the ErrorHandler. The panic is logged and reported; if nothing was sent
+// yet the client gets 500 Internal Server Error, otherwise the response cannot
+// be fixed up and the caller just closes the connection. req is nil when the
+// request could not be parsed.
+func (s *Server) recovered(w *response.Writer, req *request.Request, v any) {
+	stack := debug.Stack()
+	if req != nil {
+		s.cfg.Logger.Printf("panic serving %s %s: %v\n%s",
+			req.RequestLine.Method, req.RequestLine.RequestTarget, v, stack)
+	} else {
+		s.cfg.Logger.Printf("panic writing error response: %v\n%s", v, stack)
+	}
+
+	if s.cfg.PanicHandler != nil {
+		s.cfg.PanicHandler(req, v, stack)
+	}
+
+	if !w.Written() {
+		w.SetKeepAlive(false)
+		w.Headers = response.GetDefaultHeaders()
+		w.SetEncoder(nil)
+		w.SetError(response.StatusInternalServerError)
+		w.Finish()
+	}
+}
+
+// serveRequest answers req, either on the server's own account or by calling
+// the handler, and finishes the response. It reports whether the connection
+// can be reused, which it can't after a panic.
+func (s *Server) serveRequest(w *response.Writer, req *request.Request) (keepAlive bool) {
+	defer func() {
+		if v := recover(); v != nil {
+			s.recovered(w, req, v)
+			keepAlive = false
+		}
+	}()
+
+	switch {
+	case !slices.Contains(s.cfg.Methods, req.RequestLine.Method):
+		w.StatusCode = response.StatusNotImplemented
+		s.cfg.ErrorHandler(w, &HandleError{
+			StatusCode: w.StatusCode,
+			Msg:        "method not implemented: " + req.RequestLine.Method,
+		})
+	case req.RequestLine.RequestTarget == "*":
+		s.serveOptions(w)
+	default:
+		s.cfg.Handler(w, req)
+	}
+
+	// Skip whatever body the handler left unread; if there is too much
+	// of it the connection is dropped instead. A chunked body that runs
+	// past MaxBodyBytes is only caught while it is read, so unless the
+	// handler already answered, the client is told so.
+	if err := req.BodyReader.Close(); err != nil {
+		w.SetKeepAlive(false)
+		if errors.Is(err, request.ErrBodyTooLarge) && !w.Written() {
+			w.Headers = response.GetDefaultHeaders()
+			w.SetEncoder(nil)
+			w.SetError(response.StatusContentTooLarge)
+		}
+	}
+
+	return s.finish(w, req)
+}
+
+// handle serves requests on conn until the client asks to close, the idle
+// timeout expires between requests, or the per-connection request cap is hit.
+// Requests are handled one at a time, so pipelined requests get their
+// responses in the order they were sent.
+func (s *Server) handle(conn net.Conn) {
+	defer s.untrack(conn)
+	defer conn.Close()
+
+	reader := request.NewReader(conn)
+	reader.Limits = s.cfg.Limits
+
+	for served := 1; !s.closed.Load(); served++ {
+		// Nothing has been sent yet, so running out of time here just
+		// means the connection was idle.
+		s.setState(conn, stateIdle)
+		conn.SetReadDeadline(deadline(time.Now(), s.waitTimeout(served)))
+		if err := reader.WaitForRequest(); err != nil {
+			return
+		}
+		s.setState(conn, stateActive)
+
+		start := time.Now()
+		conn.SetReadDeadline(deadline(start, s.headerTimeout()))
+
+		req, err := reader.ReadRequest()
+		if err != nil {
+			switch {
+			case errors.Is(err, io.EOF):
+			case isTimeout(err):
+				s.writeError(conn, response.StatusRequestTimeout, err)
+			default:
+				s.writeError(conn, errorStatus(err), err)
+			}
+			return
+		}
+
+		conn.SetReadDeadline(deadline(start, s.cfg.ReadTimeout))
+		conn.SetWriteDeadline(deadline(time.Now(), s.cfg.WriteTimeout))
+
+		rw := response.NewWriter(conn)
+		rw.SetRequestMethod(req.RequestLine.Method)
+		rw.SetHTTPVersion(req.RequestLine.HttpVersion)
+		rw.SetKeepAlive(req.KeepAlive() &&
+			(s.cfg.MaxRequestsPerConn <= 0 || served < s.cfg.MaxRequestsPerConn) &&
+			!s.closed.Load())
+
+		// A shutdown that starts while the handler runs still lets this
+		// response finish, but tells the client not to send another.
+		rw.OnWriteHeader(func(w *response.Writer) {
+			if s.closed.Load() {
+				w.SetKeepAlive(false)
+				w.Headers.Set(response.Conn, "close")
+			}
+		})
+
+		// The client holds the body back until it hears 100 Continue,
+		// which is only sent once the handler starts reading. A handler
+		// that answers without reading rejects the body unseen.
+		if req.ExpectsContinue() {
+			req.OnBodyRead(func() error {
+				if rw.Written() {
+					return nil
+				}
+				return rw.WriteInformational(response.StatusContinue, nil)
+			})
+		}
+
+		if !s.serveRequest(rw, req) {
+			return
+		}
+	}
 }
 
 func (s *Server) listen() {
+	var delay time.Duration
 	for {
 		conn, err := s.listener.Accept()
 		if err != nil {
-			log.Println(err)
 			if s.closed.Load() {
 				return
 			}
+
+			delay = min(max(2*delay, minAcceptDelay), maxAcceptDelay)
+			s.cfg.Logger.Printf("accept error: %v; retrying in %v", err, delay)
+			time.Sleep(delay)
+			continue
 		}
+		delay = 0
+
+		s.mu.Lock()
+		if s.closed.Load() {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = stateIdle
+		s.mu.Unlock()
+
 		go s.handle(conn)
 	}
 }
 
-func Serve(port int, handler HandlerFunc) (*Server, error) {
+// Serve listens on the given port on all interfaces. It is shorthand for
+// ServeConfig with DefaultConfig adjusted by opts.
+func Serve(port int, handler HandlerFunc, opts ...Option) (*Server, error) {
+	cfg := DefaultConfig()
+	cfg.Addr = fmt.Sprintf(":%d", port)
+	cfg.Handler = handler
 
-	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
-	if err != nil {
-		log.Println(err)
-		return nil, err
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return ServeConfig(cfg)
+}
+
+// ServeConfig starts a server described by cfg and returns once it is
+// accepting connections.
+func ServeConfig(cfg Config) (*Server, error) {
+	if cfg.Handler == nil {
+		return nil, errors.New("server: no handler configured")
+	}
+
+	if cfg.Logger == nil {
+		cfg.Logger = log.Default()
+	}
+
+	if cfg.ErrorHandler == nil {
+		cfg.ErrorHandler = defaultErrorHandler
+	}
+
+	if cfg.Methods == nil {
+		cfg.Methods = DefaultMethods
+	}
+
+	ln := cfg.Listener
+	if ln == nil {
+		var err error
+		ln, err = net.Listen("tcp", cfg.Addr)
+		if err != nil {
+			cfg.Logger.Println(err)
+			return nil, err
+		}
 	}
 
 	s := &Server{
+		cfg:      cfg,
 		listener: ln,
-		handler:  handler,
+		conns:    make(map[net.Conn]connState),
 	}
 
 	go s.listen()
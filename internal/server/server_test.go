@@ -0,0 +1,553 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tsironi93/miniHttp/internal/request"
+	"github.com/tsironi93/miniHttp/internal/response"
+)
+
+func startServer(t *testing.T, handler HandlerFunc, opts ...Option) *Server {
+	t.Helper()
+
+	cfg := DefaultConfig()
+	cfg.Addr = "127.0.0.1:0"
+	cfg.Handler = handler
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	s, err := ServeConfig(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// roundTrip writes raw to a new connection and returns everything the server
+// sends back until it closes the connection.
+func roundTrip(t *testing.T, s *Server, raw string) string {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.WriteString(conn, raw)
+	require.NoError(t, err)
+
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(out)
+}
+
+func echoTarget(w *response.Writer, req *request.Request) {
+	w.WriteString(req.RequestLine.RequestTarget)
+}
+
+func TestServeConfig(t *testing.T) {
+	s := startServer(t, echoTarget)
+	addr, ok := s.Addr().(*net.TCPAddr)
+	require.True(t, ok)
+	assert.NotZero(t, addr.Port)
+
+	// Test: Existing listener is used as is
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s2, err := ServeConfig(Config{Listener: ln, Handler: echoTarget})
+	require.NoError(t, err)
+	defer s2.Close()
+	assert.Equal(t, ln.Addr(), s2.Addr())
+
+	// Test: Handler is required
+	_, err = ServeConfig(Config{Addr: "127.0.0.1:0"})
+	require.Error(t, err)
+}
+
+func TestKeepAlive(t *testing.T) {
+	s := startServer(t, echoTarget)
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	br := bufio.NewReader(conn)
+
+	requests := []struct {
+		raw   string
+		want  string
+		close bool
+	}{
+		{"GET /first HTTP/1.1\r\nHost: localhost\r\n\r\n", "/first", false},
+		{"GET /second HTTP/1.1\r\nHost: localhost\r\n\r\n", "/second", false},
+		{"\r\nGET /after-crlf HTTP/1.1\r\nHost: localhost\r\n\r\n", "/after-crlf", false},
+		{"GET /third HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n", "/third", true},
+	}
+
+	for _, tt := range requests {
+		_, err := io.WriteString(conn, tt.raw)
+		require.NoError(t, err)
+
+		resp, err := http.ReadResponse(br, nil)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, string(body))
+		assert.Equal(t, tt.close, resp.Close)
+	}
+
+	// Test: Server closes the connection after Connection: close
+	rest, err := io.ReadAll(br)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+}
+
+func TestKeepAlivePipelining(t *testing.T) {
+	s := startServer(t, echoTarget)
+
+	out := roundTrip(t, s,
+		"GET /first HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+			"POST /second HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello"+
+			"GET /third HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+
+	assert.Equal(t, 3, strings.Count(out, "HTTP/1.1 200 OK"))
+	first := strings.Index(out, "/first")
+	second := strings.Index(out, "/second")
+	third := strings.Index(out, "/third")
+	assert.True(t, first < second && second < third, "responses out of order: %q", out)
+	assert.Equal(t, 2, strings.Count(out, "Connection: keep-alive"))
+	assert.Equal(t, 1, strings.Count(out, "Connection: close"))
+}
+
+func TestShortContentLength(t *testing.T) {
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.Path == "/short" {
+			w.Headers.Set(response.ContLen, "10")
+		}
+		w.WriteString(req.RequestLine.Path)
+	})
+
+	// Test: Connection is closed instead of running into the next response
+	out := roundTrip(t, s,
+		"GET /short HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+			"GET /next HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, 1, strings.Count(out, "HTTP/1.1 200 OK"), out)
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n/short"), out)
+}
+
+func TestMaxRequestsPerConn(t *testing.T) {
+	s := startServer(t, echoTarget, WithMaxRequestsPerConn(2))
+
+	out := roundTrip(t, s,
+		"GET /1 HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+			"GET /2 HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+			"GET /3 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	assert.Equal(t, 2, strings.Count(out, "HTTP/1.1 200 OK"))
+	assert.NotContains(t, out, "/3")
+}
+
+func TestLimitResponses(t *testing.T) {
+	s := startServer(t, echoTarget, WithLimits(request.Limits{
+		MaxRequestLineBytes: 64,
+		MaxHeaderBytes:      128,
+		MaxBodyBytes:        4,
+	}))
+
+	out := roundTrip(t, s, "GET /"+strings.Repeat("a", 100)+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 414 URI Too Long"), out)
+
+	out = roundTrip(t, s, "GET / HTTP/1.1\r\nX-Big: "+strings.Repeat("a", 200)+"\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 431 Request Header Fields Too Large"), out)
+
+	out = roundTrip(t, s, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\n0123456789")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 413 Content Too Large"), out)
+
+	// Test: Chunked body over the limit is refused even if the handler ignores it
+	out = roundTrip(t, s, "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"3\r\nabc\r\n3\r\ndef\r\n0\r\n\r\n"+
+		"GET /next HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 413 Content Too Large"), out)
+	assert.Contains(t, out, "Connection: close")
+	assert.NotContains(t, out, "/next")
+
+	// Test: Conflicting framing is refused outright
+	out = roundTrip(t, s, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 3\r\n"+
+		"Transfer-Encoding: chunked\r\n\r\n0\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request"), out)
+
+	// Test: Unknown transfer coding
+	out = roundTrip(t, s, "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: gzip\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 501 Not Implemented"), out)
+}
+
+func TestMethods(t *testing.T) {
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		w.WriteString(req.RequestLine.Method)
+	}, WithMethods("GET", "PURGE"))
+
+	// Test: Registered extension method reaches the handler
+	out := roundTrip(t, s, "PURGE /cache HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK"), out)
+	assert.True(t, strings.HasSuffix(out, "PURGE"), out)
+
+	// Test: Unregistered method gets 501 and the connection stays usable
+	out = roundTrip(t, s,
+		"DELETE /x HTTP/1.1\r\nHost: localhost\r\nContent-Length: 3\r\n\r\nabc"+
+			"GET /x HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 501 Not Implemented"), out)
+	assert.True(t, strings.HasSuffix(out, "GET"), out)
+
+	// Test: OPTIONS * lists the implemented methods
+	s = startServer(t, echoTarget)
+	out = roundTrip(t, s, "OPTIONS * HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK"), out)
+	assert.Contains(t, out, "Allow: GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS\r\n")
+	assert.Contains(t, out, "Content-Length: 0\r\n")
+
+	// Test: CONNECT and TRACE are not implemented unless enabled
+	out = roundTrip(t, s, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 501 Not Implemented"), out)
+	out = roundTrip(t, s, "TRACE / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 501 Not Implemented"), out)
+
+	s = startServer(t, echoTarget, WithMethods(request.MethodGet, request.MethodConnect))
+	out = roundTrip(t, s, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK"), out)
+	assert.True(t, strings.HasSuffix(out, "example.com:443"), out)
+}
+
+func TestHeadRequest(t *testing.T) {
+	s := startServer(t, echoTarget)
+
+	out := roundTrip(t, s,
+		"HEAD /first HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+			"GET /second HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+
+	assert.Equal(t, 2, strings.Count(out, "HTTP/1.1 200 OK"))
+	assert.Contains(t, out, "Content-Length: 6\r\n")
+	assert.Contains(t, out, "Content-Length: 7\r\n")
+	assert.NotContains(t, out, "/first")
+	assert.True(t, strings.HasSuffix(out, "/second"), out)
+}
+
+func TestHTTP10(t *testing.T) {
+	s := startServer(t, echoTarget)
+
+	// Test: HTTP/1.0 closes after one response by default
+	out := roundTrip(t, s,
+		"GET /first HTTP/1.0\r\n\r\n"+
+			"GET /second HTTP/1.0\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.0 200 OK\r\n"), out)
+	assert.Contains(t, out, "Connection: close\r\n")
+	assert.NotContains(t, out, "/second")
+
+	// Test: HTTP/1.0 keep-alive on request
+	out = roundTrip(t, s,
+		"GET /first HTTP/1.0\r\nConnection: keep-alive\r\n\r\n"+
+			"GET /second HTTP/1.0\r\n\r\n")
+	assert.Equal(t, 2, strings.Count(out, "HTTP/1.0 200 OK"))
+	assert.Contains(t, out, "Connection: keep-alive\r\n")
+
+	// Test: Unsupported major version
+	out = roundTrip(t, s, "GET / HTTP/2.0\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 505 HTTP Version Not Supported"), out)
+}
+
+func TestExpectContinue(t *testing.T) {
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.Path == "/reject" {
+			w.SetError(response.StatusContentTooLarge)
+			return
+		}
+		body, err := req.ReadBody()
+		if !assert.NoError(t, err) {
+			w.SetError(response.StatusBadRequest)
+			return
+		}
+		w.Write(body)
+	})
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Test: 100 Continue arrives before the body is sent
+	io.WriteString(conn, "POST / HTTP/1.1\r\nHost: localhost\r\n"+
+		"Expect: 100-continue\r\nContent-Length: 5\r\nConnection: close\r\n\r\n")
+	interim := make([]byte, len("HTTP/1.1 100 Continue\r\n\r\n"))
+	_, err = io.ReadFull(conn, interim)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n\r\n", string(interim))
+
+	io.WriteString(conn, "hello")
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 200 OK"), string(out))
+	assert.True(t, strings.HasSuffix(string(out), "hello"), string(out))
+
+	// Test: Handler rejects the request without the body being sent
+	out2 := roundTrip(t, s, "POST /reject HTTP/1.1\r\nHost: localhost\r\n"+
+		"Expect: 100-continue\r\nContent-Length: 5000000\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out2, "HTTP/1.1 413 Content Too Large"), out2)
+	assert.NotContains(t, out2, "100 Continue")
+	assert.Contains(t, out2, "Connection: close\r\n")
+
+	// Test: Unknown expectation
+	out2 = roundTrip(t, s, "POST / HTTP/1.1\r\nHost: localhost\r\n"+
+		"Expect: teapot\r\nContent-Length: 5\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out2, "HTTP/1.1 417 Expectation Failed"), out2)
+}
+
+func TestReadHeaderTimeout(t *testing.T) {
+	s := startServer(t, echoTarget, WithReadHeaderTimeout(100*time.Millisecond))
+
+	out := roundTrip(t, s, "GET / HTTP/1.1\r\nHost: local")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 408 Request Timeout"), out)
+}
+
+func TestShutdownWaitsForHandlers(t *testing.T) {
+	started := make(chan struct{})
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.WriteString("done")
+	})
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	// The response is read in the background, so that Shutdown is called
+	// while the handler is still running.
+	var out []byte
+	readErr := make(chan error, 1)
+	go func() {
+		var err error
+		out, err = io.ReadAll(conn)
+		readErr <- err
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, s.Shutdown(ctx))
+
+	require.NoError(t, <-readErr)
+	assert.True(t, strings.HasSuffix(string(out), "done"), string(out))
+	assert.Contains(t, string(out), "Connection: close")
+
+	_, err = net.Dial("tcp", s.Addr().String())
+	require.Error(t, err)
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-release
+	})
+	defer close(release)
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+}
+
+// failingListener fails the first failures calls to Accept, like a listener
+// that ran out of file descriptors.
+type failingListener struct {
+	net.Listener
+	failures atomic.Int32
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	if l.failures.Add(-1) >= 0 {
+		return nil, errors.New("too many open files")
+	}
+	return l.Listener.Accept()
+}
+
+func TestAcceptBackoff(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	fl := &failingListener{Listener: ln}
+	fl.failures.Store(4)
+
+	start := time.Now()
+	cfg := DefaultConfig()
+	cfg.Listener = fl
+	cfg.Handler = echoTarget
+	cfg.Logger = log.New(io.Discard, "", 0)
+	s, err := ServeConfig(cfg)
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Accept is retried after 5, 10, 20 and 40ms
+	out := roundTrip(t, s, "GET /after HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, "/after"), out)
+	assert.GreaterOrEqual(t, time.Since(start), 75*time.Millisecond)
+}
+
+func TestChain(t *testing.T) {
+	var order []string
+	mw := func(name string) Middleware {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(w *response.Writer, req *request.Request) {
+				order = append(order, name+" in")
+				next(w, req)
+				order = append(order, name+" out")
+			}
+		}
+	}
+
+	h := Chain(func(w *response.Writer, req *request.Request) {
+		order = append(order, "handler")
+	}, mw("a"), mw("b"))
+	h(nil, nil)
+
+	assert.Equal(t, []string{"a in", "b in", "handler", "b out", "a out"}, order)
+}
+
+func TestMiddlewareChangesStatus(t *testing.T) {
+	rewrite := func(next HandlerFunc) HandlerFunc {
+		return func(w *response.Writer, req *request.Request) {
+			w.OnWriteHeader(func(w *response.Writer) {
+				w.StatusCode = response.StatusNotFound
+				w.Headers.Set("X-Rewritten", "yes")
+			})
+			next(w, req)
+		}
+	}
+	s := startServer(t, Chain(echoTarget, rewrite))
+
+	out := roundTrip(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found"), out)
+	assert.Contains(t, out, "X-Rewritten: yes")
+}
+
+func TestPanicRecovery(t *testing.T) {
+	var logs strings.Builder
+	reported := make(chan any, 1)
+
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		switch req.RequestLine.RequestTarget {
+		case "/ok":
+			w.WriteString("fine")
+			return
+		case "/late":
+			w.WriteStatusLine()
+		case "/hook":
+			w.OnWriteHeader(func(w *response.Writer) {
+				w.Headers.Set("Content-Encoding", "broken")
+				panic("boom")
+			})
+			w.WriteString("never sent")
+			return
+		}
+		panic("boom")
+	},
+		WithLogger(log.New(&logs, "", 0)),
+		WithPanicHandler(func(req *request.Request, v any, stack []byte) {
+			reported <- v
+		}))
+
+	// Test: Panic before anything was written
+	out := roundTrip(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 Internal Server Error"), out)
+	assert.Contains(t, out, "Connection: close")
+	assert.Equal(t, "boom", <-reported)
+
+	// Test: Panic after the status line was sent just drops the connection
+	out = roundTrip(t, s, "GET /late HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", out)
+	assert.Equal(t, "boom", <-reported)
+
+	// Test: Panic in a header hook run by Finish
+	out = roundTrip(t, s, "GET /hook HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 Internal Server Error"), out)
+	assert.NotContains(t, out, "Content-Encoding")
+	assert.NotContains(t, out, "never sent")
+	assert.Equal(t, "boom", <-reported)
+
+	// Test: Server keeps serving
+	out = roundTrip(t, s, "GET /ok HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK"), out)
+	assert.Contains(t, logs.String(), "panic serving GET /")
+}
+
+func TestErrorHandlerPanic(t *testing.T) {
+	var logs strings.Builder
+	s := startServer(t, echoTarget,
+		WithLogger(log.New(&logs, "", 0)),
+		WithErrorHandler(func(w *response.Writer, herr *HandleError) {
+			panic("bad error handler")
+		}))
+
+	// Test: Unimplemented method
+	out := roundTrip(t, s, "BREW / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 Internal Server Error"), out)
+
+	// Test: Request that could not be parsed
+	out = roundTrip(t, s, "GET /\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 Internal Server Error"), out)
+	assert.Contains(t, logs.String(), "panic writing error response: bad error handler")
+}
+
+func TestInvalidStatus(t *testing.T) {
+	var logs strings.Builder
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		switch req.RequestLine.Path {
+		case "/42":
+			w.StatusCode = 42
+		case "/continue":
+			w.StatusCode = response.StatusContinue
+			w.WriteString(strings.Repeat("x", 5000))
+		case "/length":
+			w.Headers.Set(response.ContLen, "2")
+			w.WriteString("abc")
+		}
+	}, WithLogger(log.New(&logs, "", 0)))
+
+	// Test: Code without three digits
+	out := roundTrip(t, s, "GET /42 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 Internal Server Error"), out)
+	assert.Contains(t, out, "Connection: close")
+
+	// Test: Interim code as the final status, with a body big enough to
+	// be flushed early
+	out = roundTrip(t, s, "GET /continue HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 Internal Server Error"), out)
+	assert.NotContains(t, out, "100 Continue")
+	assert.NotContains(t, out, "xxx")
+	assert.Contains(t, logs.String(), "GET /42: invalid status code 42; sending 500 instead")
+
+	// Test: Buffered body longer than the handler's Content-Length
+	out = roundTrip(t, s, "GET /length HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 Internal Server Error"), out)
+	assert.NotContains(t, out, "abc")
+	assert.Contains(t, logs.String(), "GET /length: body longer than the declared Content-Length")
+}